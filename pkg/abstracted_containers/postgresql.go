@@ -2,9 +2,14 @@ package abstractedcontainers
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"path"
+	"path/filepath"
+	"sort"
 
 	"github.com/docker/go-connections/nat"
+	"github.com/jackc/pgx/v5"
 	"github.com/testcontainers/testcontainers-go"
 	"github.com/testcontainers/testcontainers-go/wait"
 )
@@ -13,53 +18,321 @@ type PostgreSQLContainer struct {
 	testcontainers.Container
 	Host          string
 	MappedPort    string
+	User          string
+	Password      string
 	DB            string
 	ConnectionURL string
 }
 
+// Connection URL to another database of the same server
+func (c *PostgreSQLContainer) ConnectionURLForDB(db string) string {
+	return c.ConnectionURLForAddr(c.Host, c.MappedPort, db)
+}
+
+// Connection URL to a database of the server reached at another address, such as a proxy in front of it
+func (c *PostgreSQLContainer) ConnectionURLForAddr(host, port, db string) string {
+	return fmt.Sprintf("postgres://%s:%s@%s:%s/%s", c.User, c.Password, host, port, db)
+}
+
+const (
+	DefaultPostgreSQLImage = "postgres"
+	DefaultPostgreSQLTag   = "14.5"
+	// Data directory of the official image, mounted as tmpfs when asked to
+	postgreSQLDataDir = "/var/lib/postgresql/data"
+	// Scripts in this directory of the official image run when the database is first initialised
+	postgreSQLInitScriptsDir = "/docker-entrypoint-initdb.d"
+)
+
+type PostgreSQLContainerOptions struct {
+	User     string
+	Password string
+	DB       string
+	// Defaults to DefaultPostgreSQLImage and DefaultPostgreSQLTag
+	Image string
+	Tag   string
+	// Server configuration, passed as -c name=value, e.g. fsync: off
+	Settings map[string]string
+	// .sql or .sh files on the host, run in the given order when the database is initialised
+	InitScripts []string
+	// Extensions created in the database once it is started
+	Extensions []string
+	// Keep the data directory in memory, trading durability for speed
+	TmpfsDataDir bool
+}
+
 func SetupPostgreSQL(ctx context.Context, user, pass, db string) (*PostgreSQLContainer, error) {
+	return SetupPostgreSQLWithOptions(ctx, PostgreSQLContainerOptions{User: user, Password: pass, DB: db})
+}
+
+func SetupPostgreSQLWithOptions(ctx context.Context, opts PostgreSQLContainerOptions) (*PostgreSQLContainer, error) {
 	postgreSQLPort, err := nat.NewPort("tcp", "5432")
 	if err != nil {
 		return nil, err
 	}
 
 	req := testcontainers.ContainerRequest{
-		Image:        "postgres:14.5",
+		Image:        PostgreSQLImage(opts.Image, opts.Tag),
 		ExposedPorts: []string{fmt.Sprintf("%s/%s", postgreSQLPort.Port(), postgreSQLPort.Proto())},
 		WaitingFor:   wait.ForListeningPort(postgreSQLPort),
 		Env: map[string]string{
-			"POSTGRES_PASSWORD": pass,
-			"POSTGRES_USER":     user,
-			"POSTGRES_DB":       db,
+			"POSTGRES_PASSWORD": opts.Password,
+			"POSTGRES_USER":     opts.User,
+			"POSTGRES_DB":       opts.DB,
 		},
+		Cmd:        postgreSQLServerCmd(opts.Settings),
+		Files:      postgreSQLInitScriptFiles(opts.InitScripts),
 		AutoRemove: true,
 	}
 
+	if opts.TmpfsDataDir {
+		req.Tmpfs = map[string]string{postgreSQLDataDir: "rw"}
+	}
+
 	postgreSQLC, err := testcontainers.GenericContainer(ctx, testcontainers.GenericContainerRequest{
 		ContainerRequest: req,
 		Started:          true,
 	})
 	if err != nil {
+		// a container that was created but failed to start is returned along with the error
+		if postgreSQLC != nil {
+			_ = postgreSQLC.Terminate(context.Background())
+		}
+
+		return nil, err
+	}
+
+	// the container is started, so it has to be terminated on any later failure
+	terminate := func(err error) (*PostgreSQLContainer, error) {
+		_ = postgreSQLC.Terminate(context.Background())
 		return nil, err
 	}
 
 	mappedPort, err := postgreSQLC.MappedPort(ctx, postgreSQLPort)
 	if err != nil {
-		return nil, err
+		return terminate(err)
 	}
 
 	hostIP, err := postgreSQLC.Host(ctx)
 	if err != nil {
-		return nil, err
+		return terminate(err)
+	}
+
+	container := &PostgreSQLContainer{
+		Container:  postgreSQLC,
+		Host:       hostIP,
+		MappedPort: mappedPort.Port(),
+		User:       opts.User,
+		Password:   opts.Password,
+		DB:         opts.DB,
+	}
+
+	container.ConnectionURL = container.ConnectionURLForDB(opts.DB)
+
+	if len(opts.Extensions) > 0 {
+		if err := container.createExtensions(ctx, opts.Extensions); err != nil {
+			return terminate(err)
+		}
+	}
+
+	return container, nil
+}
+
+// Image reference with the defaults applied to the empty parts
+func PostgreSQLImage(image, tag string) string {
+	if image == "" {
+		image = DefaultPostgreSQLImage
+	}
+
+	if tag == "" {
+		tag = DefaultPostgreSQLTag
+	}
+
+	return fmt.Sprintf("%s:%s", image, tag)
+}
+
+// Command of the container, nil keeps the default of the image
+func postgreSQLServerCmd(settings map[string]string) []string {
+	if len(settings) == 0 {
+		return nil
+	}
+
+	names := make([]string, 0, len(settings))
+	for name := range settings {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	cmd := []string{"postgres"}
+	for _, name := range names {
+		cmd = append(cmd, "-c", fmt.Sprintf("%s=%s", name, settings[name]))
+	}
+
+	return cmd
+}
+
+// The init scripts directory runs files sorted by name, so prefix them to keep the given order
+func postgreSQLInitScriptFiles(scripts []string) []testcontainers.ContainerFile {
+	files := make([]testcontainers.ContainerFile, len(scripts))
+
+	for i, script := range scripts {
+		files[i] = testcontainers.ContainerFile{
+			HostFilePath:      script,
+			ContainerFilePath: path.Join(postgreSQLInitScriptsDir, fmt.Sprintf("%03d_%s", i, filepath.Base(script))),
+			FileMode:          0o755,
+		}
+	}
+
+	return files
+}
+
+func (c *PostgreSQLContainer) createExtensions(ctx context.Context, extensions []string) error {
+	conn, err := pgx.Connect(ctx, c.ConnectionURL)
+	if err != nil {
+		return err
+	}
+
+	defer conn.Close(ctx)
+
+	for _, extension := range extensions {
+		if _, err := conn.Exec(ctx, fmt.Sprintf(`CREATE EXTENSION IF NOT EXISTS %s;`, pgx.Identifier{extension}.Sanitize())); err != nil {
+			return fmt.Errorf("creating extension %s: %w", extension, err)
+		}
+	}
+
+	return nil
+}
+
+// Returned when copying or replacing a database that still has open connections, as PostgreSQL refuses to
+var ErrDatabaseInUse = errors.New("database has open connections")
+
+// PostgreSQL silently truncates longer identifiers
+const maxIdentifierLength = 63
+
+// Capture the current state of the container database under the given name, replacing any previous snapshot of that name.
+// All the connections to the database, such as the ones of a pool, must be closed beforehand.
+func (c *PostgreSQLContainer) Snapshot(ctx context.Context, name string) error {
+	snapshot, err := snapshotDatabaseName(name)
+	if err != nil {
+		return err
+	}
+
+	return c.WithMaintenanceConn(ctx, func(conn *pgx.Conn) error {
+		if err := ensureNoConnections(ctx, conn, c.DB); err != nil {
+			return err
+		}
+
+		if _, err := conn.Exec(ctx, fmt.Sprintf(`DROP DATABASE IF EXISTS %s;`, pgx.Identifier{snapshot}.Sanitize())); err != nil {
+			return err
+		}
+
+		_, err := conn.Exec(ctx, fmt.Sprintf(
+			`CREATE DATABASE %s TEMPLATE %s;`,
+			pgx.Identifier{snapshot}.Sanitize(),
+			pgx.Identifier{c.DB}.Sanitize(),
+		))
+
+		return err
+	})
+}
+
+// Bring the container database back to the state captured by Snapshot under the given name.
+// All the connections to the database, such as the ones of a pool, must be closed beforehand.
+func (c *PostgreSQLContainer) Restore(ctx context.Context, name string) error {
+	snapshot, err := snapshotDatabaseName(name)
+	if err != nil {
+		return err
+	}
+
+	restored := fmt.Sprintf("%s_restoring", snapshot)
+	replaced := fmt.Sprintf("%s_replaced", snapshot)
+
+	return c.WithMaintenanceConn(ctx, func(conn *pgx.Conn) error {
+		var exists bool
+
+		if err := conn.QueryRow(ctx, `SELECT EXISTS (SELECT 1 FROM pg_database WHERE datname = $1);`, snapshot).Scan(&exists); err != nil {
+			return err
+		}
+
+		if !exists {
+			return fmt.Errorf("snapshot %q does not exist", name)
+		}
+
+		if err := ensureNoConnections(ctx, conn, c.DB); err != nil {
+			return err
+		}
+
+		// copy first and move the current database aside, so that a failure leaves it in place
+		statements := []string{
+			fmt.Sprintf(`DROP DATABASE IF EXISTS %s;`, pgx.Identifier{restored}.Sanitize()),
+			fmt.Sprintf(`DROP DATABASE IF EXISTS %s;`, pgx.Identifier{replaced}.Sanitize()),
+			fmt.Sprintf(`CREATE DATABASE %s TEMPLATE %s;`, pgx.Identifier{restored}.Sanitize(), pgx.Identifier{snapshot}.Sanitize()),
+			renameDatabase(c.DB, replaced),
+		}
+
+		for _, v := range statements {
+			if _, err := conn.Exec(ctx, v); err != nil {
+				return err
+			}
+		}
+
+		if _, err := conn.Exec(ctx, renameDatabase(restored, c.DB)); err != nil {
+			// use a fresh context, the failure may come from ctx being done
+			if _, rollbackErr := conn.Exec(context.Background(), renameDatabase(replaced, c.DB)); rollbackErr != nil {
+				return fmt.Errorf("restoring snapshot %q: %v, moving database %s back from %s: %w", name, err, c.DB, replaced, rollbackErr)
+			}
+
+			return err
+		}
+
+		_, err := conn.Exec(ctx, fmt.Sprintf(`DROP DATABASE %s;`, pgx.Identifier{replaced}.Sanitize()))
+		return err
+	})
+}
+
+func renameDatabase(from, to string) string {
+	return fmt.Sprintf(`ALTER DATABASE %s RENAME TO %s;`, pgx.Identifier{from}.Sanitize(), pgx.Identifier{to}.Sanitize())
+}
+
+func snapshotDatabaseName(name string) (string, error) {
+	// leave room for the suffixes used while restoring
+	snapshot := fmt.Sprintf("snapshot_%s", name)
+	if len(snapshot)+len("_restoring") > maxIdentifierLength {
+		return "", fmt.Errorf("snapshot name %q is too long", name)
+	}
+
+	return snapshot, nil
+}
+
+func ensureNoConnections(ctx context.Context, conn *pgx.Conn, db string) error {
+	var open int
+
+	if err := conn.QueryRow(
+		ctx,
+		`SELECT count(*) FROM pg_stat_activity WHERE datname = $1 AND pid <> pg_backend_pid();`,
+		db,
+	).Scan(&open); err != nil {
+		return err
+	}
+
+	if open > 0 {
+		return fmt.Errorf("%w: %d connections to %s are still open, close them first", ErrDatabaseInUse, open, db)
+	}
+
+	return nil
+}
+
+// Run fn on a short lived connection to the always present postgres database,
+// for administrative statements that cannot run on the database they affect
+func (c *PostgreSQLContainer) WithMaintenanceConn(ctx context.Context, fn func(conn *pgx.Conn) error) error {
+	conn, err := pgx.Connect(ctx, c.ConnectionURLForDB("postgres"))
+	if err != nil {
+		return err
 	}
 
-	uri := fmt.Sprintf("postgres://%s:%s@%s:%s/%s", user, pass, hostIP, mappedPort.Port(), db)
+	defer func() {
+		_ = conn.Close(context.Background())
+	}()
 
-	return &PostgreSQLContainer{
-		Container:     postgreSQLC,
-		Host:          hostIP,
-		MappedPort:    mappedPort.Port(),
-		DB:            db,
-		ConnectionURL: uri,
-	}, nil
+	return fn(conn)
 }
@@ -0,0 +1,241 @@
+package sakerhet
+
+import (
+	"context"
+	"net"
+	"sync"
+	"time"
+)
+
+// Local TCP proxy in front of a service, injecting network faults on command.
+// Point the code under test to Addr instead of the service to make it go through the faults.
+type FaultProxy struct {
+	listener net.Listener
+	target   string
+
+	mu        sync.Mutex
+	latency   time.Duration
+	bandwidth int
+	refuse    bool
+	// target side of each client connection
+	conns  map[net.Conn]net.Conn
+	closed bool
+
+	// bounds the dials to the target to the lifetime of the proxy
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	wg sync.WaitGroup
+}
+
+// Time given to the target to accept a connection, so that an unreachable one does not hold clients forever
+const faultProxyDialTimeout = 10 * time.Second
+
+// Start a proxy listening on a random local port and forwarding to the target host:port
+func NewFaultProxy(target string) (*FaultProxy, error) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	f := &FaultProxy{
+		listener: listener,
+		target:   target,
+		conns:    make(map[net.Conn]net.Conn),
+		ctx:      ctx,
+		cancel:   cancel,
+	}
+
+	f.wg.Add(1)
+	go f.accept()
+
+	return f, nil
+}
+
+// Address of the proxy, as host:port
+func (f *FaultProxy) Addr() string {
+	return f.listener.Addr().String()
+}
+
+// Delay every chunk of data forwarded, in both directions
+func (f *FaultProxy) SetLatency(d time.Duration) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.latency = d
+}
+
+// Limit each direction of every connection to the given bytes per second, 0 for no limit
+func (f *FaultProxy) SetBandwidth(bytesPerSecond int) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.bandwidth = bytesPerSecond
+}
+
+// Close new connections right after accepting them, while refuse is true
+func (f *FaultProxy) RefuseConnections(refuse bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.refuse = refuse
+}
+
+// Close every open connection, including the ones in the middle of a query
+func (f *FaultProxy) DropConnections() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	for client, target := range f.conns {
+		_ = client.Close()
+		_ = target.Close()
+	}
+}
+
+// Number of open client connections
+func (f *FaultProxy) ActiveConnections() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return len(f.conns)
+}
+
+// Remove all the faults, leaving the open connections as they are
+func (f *FaultProxy) Reset() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.latency = 0
+	f.bandwidth = 0
+	f.refuse = false
+}
+
+// Stop listening and close every connection
+func (f *FaultProxy) Close() error {
+	f.mu.Lock()
+	f.closed = true
+	f.mu.Unlock()
+
+	err := f.listener.Close()
+
+	f.cancel()
+	f.DropConnections()
+	f.wg.Wait()
+
+	return err
+}
+
+func (f *FaultProxy) accept() {
+	defer f.wg.Done()
+
+	for {
+		client, err := f.listener.Accept()
+		if err != nil {
+			return
+		}
+
+		f.mu.Lock()
+		refuse := f.refuse || f.closed
+		f.mu.Unlock()
+
+		if refuse {
+			_ = client.Close()
+			continue
+		}
+
+		// dialed aside, so that a slow target does not hold back the next clients
+		f.wg.Add(1)
+		go f.connect(client)
+	}
+}
+
+// Connect the client to the target and forward between them, closing the client when the target cannot be reached
+func (f *FaultProxy) connect(client net.Conn) {
+	defer f.wg.Done()
+
+	dialer := net.Dialer{Timeout: faultProxyDialTimeout}
+
+	target, err := dialer.DialContext(f.ctx, "tcp", f.target)
+	if err != nil {
+		_ = client.Close()
+		return
+	}
+
+	if !f.track(client, target) {
+		_ = client.Close()
+		_ = target.Close()
+		return
+	}
+
+	f.wg.Add(2)
+	go f.forward(client, target, client)
+	go f.forward(client, client, target)
+}
+
+func (f *FaultProxy) track(client, target net.Conn) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.closed {
+		return false
+	}
+
+	f.conns[client] = target
+
+	return true
+}
+
+func (f *FaultProxy) untrack(client net.Conn) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	delete(f.conns, client)
+}
+
+// Copy from src to dst with the current faults applied, closing both sides of the client connection when either ends
+func (f *FaultProxy) forward(client, dst, src net.Conn) {
+	defer f.wg.Done()
+
+	defer func() {
+		_ = dst.Close()
+		_ = src.Close()
+		f.untrack(client)
+	}()
+
+	buf := make([]byte, 32*1024)
+
+	for {
+		f.mu.Lock()
+		latency := f.latency
+		bandwidth := f.bandwidth
+		f.mu.Unlock()
+
+		chunk := buf
+		if bandwidth > 0 && bandwidth < len(chunk) {
+			chunk = buf[:bandwidth]
+		}
+
+		n, err := src.Read(chunk)
+
+		if n > 0 {
+			delay := latency
+			if bandwidth > 0 {
+				delay += time.Duration(n) * time.Second / time.Duration(bandwidth)
+			}
+
+			if delay > 0 {
+				time.Sleep(delay)
+			}
+
+			if _, err := dst.Write(chunk[:n]); err != nil {
+				return
+			}
+		}
+
+		if err != nil {
+			return
+		}
+	}
+}
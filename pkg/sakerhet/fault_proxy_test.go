@@ -0,0 +1,185 @@
+package sakerhet_test
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/averageflow/sakerhet/pkg/sakerhet"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/suite"
+)
+
+type FaultProxyTestSuite struct {
+	suite.Suite
+	echo  net.Listener
+	proxy *sakerhet.FaultProxy
+}
+
+func TestFaultProxyTestSuite(t *testing.T) {
+	sakerhet.SkipUnitTestsWhenIntegrationTesting(t)
+	t.Parallel()
+	suite.Run(t, new(FaultProxyTestSuite))
+}
+
+func (suite *FaultProxyTestSuite) SetupTest() {
+	echo, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		suite.T().Fatal(err)
+	}
+
+	go func() {
+		for {
+			conn, err := echo.Accept()
+			if err != nil {
+				return
+			}
+
+			go func() {
+				defer conn.Close()
+				_, _ = io.Copy(conn, conn)
+			}()
+		}
+	}()
+
+	proxy, err := sakerhet.NewFaultProxy(echo.Addr().String())
+	if err != nil {
+		suite.T().Fatal(err)
+	}
+
+	suite.echo = echo
+	suite.proxy = proxy
+}
+
+func (suite *FaultProxyTestSuite) TearDownTest() {
+	assert.NoError(suite.T(), suite.proxy.Close())
+	_ = suite.echo.Close()
+}
+
+func (suite *FaultProxyTestSuite) roundTrip(conn net.Conn, message string) (string, error) {
+	if err := conn.SetDeadline(time.Now().Add(5 * time.Second)); err != nil {
+		return "", err
+	}
+
+	if _, err := conn.Write([]byte(message + "\n")); err != nil {
+		return "", err
+	}
+
+	return bufio.NewReader(conn).ReadString('\n')
+}
+
+func (suite *FaultProxyTestSuite) dial() net.Conn {
+	conn, err := net.Dial("tcp", suite.proxy.Addr())
+	if err != nil {
+		suite.T().Fatal(err)
+	}
+
+	suite.T().Cleanup(func() { _ = conn.Close() })
+
+	return conn
+}
+
+func (suite *FaultProxyTestSuite) TestForward() {
+	conn := suite.dial()
+
+	received, err := suite.roundTrip(conn, "hello")
+
+	assert.NoError(suite.T(), err)
+	assert.Equal(suite.T(), "hello\n", received)
+	assert.Equal(suite.T(), 1, suite.proxy.ActiveConnections())
+}
+
+func (suite *FaultProxyTestSuite) TestLatency() {
+	suite.proxy.SetLatency(100 * time.Millisecond)
+
+	conn := suite.dial()
+	start := time.Now()
+
+	_, err := suite.roundTrip(conn, "hello")
+
+	assert.NoError(suite.T(), err)
+	// once on the way there and once on the way back
+	assert.GreaterOrEqual(suite.T(), time.Since(start), 200*time.Millisecond)
+}
+
+func (suite *FaultProxyTestSuite) TestBandwidth() {
+	suite.proxy.SetBandwidth(100)
+
+	conn := suite.dial()
+	start := time.Now()
+
+	message := string(make([]byte, 49))
+
+	_, err := suite.roundTrip(conn, message)
+
+	assert.NoError(suite.T(), err)
+	// 50 bytes at 100 bytes per second, in both directions
+	assert.GreaterOrEqual(suite.T(), time.Since(start), time.Second)
+}
+
+func (suite *FaultProxyTestSuite) TestDropConnections() {
+	conn := suite.dial()
+
+	if _, err := suite.roundTrip(conn, "hello"); err != nil {
+		suite.T().Fatal(err)
+	}
+
+	suite.proxy.DropConnections()
+
+	_, err := suite.roundTrip(conn, "hello")
+
+	assert.Error(suite.T(), err)
+	assert.Eventually(suite.T(), func() bool { return suite.proxy.ActiveConnections() == 0 }, time.Second, 10*time.Millisecond)
+
+	// new connections still go through
+	received, err := suite.roundTrip(suite.dial(), "again")
+
+	assert.NoError(suite.T(), err)
+	assert.Equal(suite.T(), "again\n", received)
+}
+
+func (suite *FaultProxyTestSuite) TestRefuseConnections() {
+	suite.proxy.RefuseConnections(true)
+
+	_, err := suite.roundTrip(suite.dial(), "hello")
+
+	assert.Error(suite.T(), err)
+
+	suite.proxy.Reset()
+
+	received, err := suite.roundTrip(suite.dial(), "hello")
+
+	assert.NoError(suite.T(), err)
+	assert.Equal(suite.T(), "hello\n", received)
+}
+
+func (suite *FaultProxyTestSuite) TestUnreachableTarget() {
+	closed, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		suite.T().Fatal(err)
+	}
+
+	_ = closed.Close()
+
+	proxy, err := sakerhet.NewFaultProxy(closed.Addr().String())
+	if err != nil {
+		suite.T().Fatal(err)
+	}
+
+	defer proxy.Close()
+
+	conn, err := net.Dial("tcp", proxy.Addr())
+	if err != nil {
+		suite.T().Fatal(err)
+	}
+
+	defer conn.Close()
+
+	// the client is closed instead of being left waiting
+	_, err = suite.roundTrip(conn, "hello")
+
+	assert.Error(suite.T(), err)
+	assert.Equal(suite.T(), 0, proxy.ActiveConnections())
+}
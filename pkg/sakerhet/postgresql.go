@@ -2,44 +2,214 @@ package sakerhet
 
 import (
 	"context"
+	"database/sql"
 	"fmt"
 	"strings"
 
 	abstractedcontainers "github.com/averageflow/sakerhet/pkg/abstracted_containers"
 	"github.com/google/uuid"
 	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
 	"github.com/jackc/pgx/v5/pgxpool"
 )
 
+// Common interface of *pgxpool.Pool, *pgx.Conn and pgx.Tx accepted by the PostgreSQL helpers,
+// database/sql handles are adapted to it by FromSQL
+type PostgreSQLExecutor interface {
+	Exec(ctx context.Context, sql string, arguments ...any) (pgconn.CommandTag, error)
+	Query(ctx context.Context, sql string, args ...any) (pgx.Rows, error)
+	QueryRow(ctx context.Context, sql string, args ...any) pgx.Row
+}
+
+// Implemented by *pgxpool.Pool and *pgx.Conn, which begin transactions, and by pgx.Tx, which begins savepoints
+type PostgreSQLTxStarter interface {
+	Begin(ctx context.Context) (pgx.Tx, error)
+}
+
 type PostgreSQLIntegrationTestParams struct {
 	User     string
 	Password string
 	DB       string
+	// Image and tag of the container, defaulting to postgres:14.5
+	Image string
+	Tag   string
+	// Server configuration passed as -c name=value, e.g. fsync: off or max_connections: 200
+	Settings map[string]string
+	// .sql or .sh files run in the given order when the database is initialised
+	InitScripts []string
+	// Extensions created in the database once it is started
+	Extensions []string
+	// Keep the data directory on tmpfs
+	TmpfsDataDir bool
+	// Settings of the pools created by Start and NewPool
+	PoolSettings PostgreSQLPoolSettings
+	// Record the queries of the pools created by Start and NewPool in the QueryRecorder of the tester
+	RecordQueries bool
 }
 
 type PostgreSQLIntegrationTester struct {
-	User     string
-	Password string
-	DB       string
+	User         string
+	Password     string
+	DB           string
+	Image        string
+	Tag          string
+	Settings     map[string]string
+	InitScripts  []string
+	Extensions   []string
+	TmpfsDataDir bool
+	PoolSettings PostgreSQLPoolSettings
+	// Set when RecordQueries is given
+	QueryRecorder *PostgreSQLQueryRecorder
+	// Set by Start, closed by Terminate
+	Container *abstractedcontainers.PostgreSQLContainer
+	Pool      *pgxpool.Pool
+	SQLDB     *sql.DB
 }
 
 type PostgreSQLIntegrationTestSeed struct {
 	InsertQuery  string
 	InsertValues [][]any
+	// Load InsertValues into these columns of CopyTable with COPY FROM instead of running InsertQuery per row
+	CopyTable   string
+	CopyColumns []string
 }
 
 type PostgreSQLIntegrationTestExpectation struct {
 	GetQuery       string
 	ExpectedValues []any
+	// Optional, when not given each row is scanned generically into a []any of its column values
+	RowHandler func(rows pgx.Rows) (any, error)
+	// Optional fields of the scanned rows left out of the comparison, such as generated IDs or timestamps.
+	// With the generic scan they name columns of the query, whose values in the expected rows are not compared.
+	IgnoreFields []string
 }
 
 type PostgreSQLIntegrationTestSituation struct {
 	Seeds   []PostgreSQLIntegrationTestSeed
 	Expects []PostgreSQLIntegrationTestExpectation
+	// Optional action under test, executed after seeding and before checking expectations
+	Action func(ctx context.Context, db PostgreSQLExecutor) error
+	// Optional polling of the expectations until they are met, for actions with asynchronous effects
+	Eventually *PostgreSQLEventuallyOptions
+}
+
+type PostgreSQLIntegrationTestExpectationFailure struct {
+	Expectation PostgreSQLIntegrationTestExpectation
+	Difference  UnorderedDifference[any]
+	// Rows returned by the query
+	Received []any
+}
+
+// Aggregated result of all the failed expectations of a situation
+type PostgreSQLIntegrationTestReport struct {
+	TotalExpectations int
+	Failures          []PostgreSQLIntegrationTestExpectationFailure
+}
+
+func (r *PostgreSQLIntegrationTestReport) Error() string {
+	var sb strings.Builder
+
+	fmt.Fprintf(&sb, "%d of %d expectations failed:\n", len(r.Failures), r.TotalExpectations)
+
+	for _, f := range r.Failures {
+		fmt.Fprintf(&sb, "expectation %q:\n%s", strings.TrimSpace(f.Expectation.GetQuery), f.Difference)
+	}
+
+	return sb.String()
+}
+
+// Seed the data, execute the action under test and check every expectation of the situation.
+// Returns a *PostgreSQLIntegrationTestReport when any of the expectations is not met.
+func (s PostgreSQLIntegrationTestSituation) Run(ctx context.Context, db PostgreSQLExecutor) error {
+	for _, v := range s.Seeds {
+		if _, err := seedPostgreSQLData(ctx, db, v); err != nil {
+			return fmt.Errorf("seeding data: %w", err)
+		}
+	}
+
+	if s.Action != nil {
+		if err := s.Action(ctx, db); err != nil {
+			return fmt.Errorf("action under test: %w", err)
+		}
+	}
+
+	if s.Eventually != nil {
+		return EventuallyPostgreSQLExpectations(ctx, db, s.Expects, *s.Eventually)
+	}
+
+	report, err := checkPostgreSQLExpectations(ctx, db, s.Expects)
+	if err != nil {
+		return err
+	}
+
+	if report != nil {
+		return report
+	}
+
+	return nil
+}
+
+// Check every expectation once, returning a report only when any of them is not met
+func checkPostgreSQLExpectations(ctx context.Context, db PostgreSQLExecutor, expects []PostgreSQLIntegrationTestExpectation) (*PostgreSQLIntegrationTestReport, error) {
+	report := &PostgreSQLIntegrationTestReport{TotalExpectations: len(expects)}
+
+	for _, v := range expects {
+		rowHandler := v.RowHandler
+		ignored := v.IgnoreFields
+
+		var columns []string
+
+		if rowHandler == nil {
+			rowHandler = func(rows pgx.Rows) (any, error) {
+				if columns == nil {
+					for _, fd := range rows.FieldDescriptions() {
+						columns = append(columns, fd.Name)
+					}
+				}
+
+				return scanRowValues(rows)
+			}
+		}
+
+		got, err := FetchPostgreSQLData(ctx, db, v.GetQuery, rowHandler)
+		if err != nil {
+			return nil, fmt.Errorf("fetching data with %q: %w", v.GetQuery, err)
+		}
+
+		if columns != nil {
+			ignored = ignoredColumnIndexes(v.IgnoreFields, columns)
+		}
+
+		if diff := UnorderedDiffWith(v.ExpectedValues, got, UnorderedOptions[any]{IgnoreFields: ignored}); !diff.Equal() {
+			report.Failures = append(report.Failures, PostgreSQLIntegrationTestExpectationFailure{
+				Expectation: v,
+				Difference:  diff,
+				Received:    got,
+			})
+		}
+	}
+
+	if len(report.Failures) > 0 {
+		return report, nil
+	}
+
+	return nil, nil
 }
 
 func NewPostgreSQLIntegrationTester(p *PostgreSQLIntegrationTestParams) *PostgreSQLIntegrationTester {
-	newTester := &PostgreSQLIntegrationTester{}
+	newTester := &PostgreSQLIntegrationTester{
+		Image:        p.Image,
+		Tag:          p.Tag,
+		Settings:     p.Settings,
+		InitScripts:  p.InitScripts,
+		Extensions:   p.Extensions,
+		TmpfsDataDir: p.TmpfsDataDir,
+		PoolSettings: p.PoolSettings,
+	}
+
+	if p.RecordQueries {
+		newTester.QueryRecorder = NewPostgreSQLQueryRecorder()
+	}
 
 	if p.Password == "" {
 		newTester.Password = fmt.Sprintf("password-%s", uuid.NewString())
@@ -63,7 +233,17 @@ func NewPostgreSQLIntegrationTester(p *PostgreSQLIntegrationTestParams) *Postgre
 }
 
 func (g *PostgreSQLIntegrationTester) ContainerStart(ctx context.Context) (*abstractedcontainers.PostgreSQLContainer, error) {
-	postgreSQLC, err := abstractedcontainers.SetupPostgreSQL(ctx, g.User, g.Password, g.DB)
+	postgreSQLC, err := abstractedcontainers.SetupPostgreSQLWithOptions(ctx, abstractedcontainers.PostgreSQLContainerOptions{
+		User:         g.User,
+		Password:     g.Password,
+		DB:           g.DB,
+		Image:        g.Image,
+		Tag:          g.Tag,
+		Settings:     g.Settings,
+		InitScripts:  g.InitScripts,
+		Extensions:   g.Extensions,
+		TmpfsDataDir: g.TmpfsDataDir,
+	})
 	if err != nil {
 		return nil, err
 	}
@@ -71,42 +251,61 @@ func (g *PostgreSQLIntegrationTester) ContainerStart(ctx context.Context) (*abst
 	return postgreSQLC, nil
 }
 
-func (p *PostgreSQLIntegrationTester) InitSchema(ctx context.Context, dbPool *pgxpool.Pool, initialSchema []string) error {
-	if err := InitPostgreSQLSchema(ctx, dbPool, initialSchema); err != nil {
+func (p *PostgreSQLIntegrationTester) InitSchema(ctx context.Context, db PostgreSQLExecutor, initialSchema []string) error {
+	if err := InitPostgreSQLSchema(ctx, db, initialSchema); err != nil {
 		return err
 	}
 
 	return nil
 }
 
-func (p *PostgreSQLIntegrationTester) SeedData(ctx context.Context, dbPool *pgxpool.Pool, seeds []PostgreSQLIntegrationTestSeed) error {
+func (p *PostgreSQLIntegrationTester) SeedData(ctx context.Context, db PostgreSQLExecutor, seeds []PostgreSQLIntegrationTestSeed) error {
+	_, err := p.SeedDataCount(ctx, db, seeds)
+	return err
+}
+
+// Seed the data like SeedData, returning how many rows the inserts and copies affected
+func (p *PostgreSQLIntegrationTester) SeedDataCount(ctx context.Context, db PostgreSQLExecutor, seeds []PostgreSQLIntegrationTestSeed) (int64, error) {
+	var loaded int64
+
 	for _, v := range seeds {
-		if err := SeedPostgreSQLData(ctx, dbPool, v.InsertQuery, v.InsertValues); err != nil {
-			return err
+		n, err := seedPostgreSQLData(ctx, db, v)
+		loaded += n
+
+		if err != nil {
+			return loaded, err
 		}
 	}
 
-	return nil
+	return loaded, nil
+}
+
+func seedPostgreSQLData(ctx context.Context, db PostgreSQLExecutor, seed PostgreSQLIntegrationTestSeed) (int64, error) {
+	if seed.CopyTable != "" {
+		return CopyPostgreSQLData(ctx, db, seed.CopyTable, seed.CopyColumns, seed.InsertValues)
+	}
+
+	return seedPostgreSQLRows(ctx, db, seed.InsertQuery, seed.InsertValues)
 }
 
 func (p *PostgreSQLIntegrationTester) CheckContainsExpectedData(resultSet []any, expected []any) error {
-	if !UnorderedEqual(resultSet, expected) {
-		return fmt.Errorf(
-			"received data is different than expected:\n received %+v\n expected %+v\n",
-			resultSet,
-			expected,
-		)
+	if diff := UnorderedDiff(expected, resultSet); !diff.Equal() {
+		return fmt.Errorf("received data is different than expected:\n%s", diff)
 	}
 
 	return nil
 }
 
-func (p *PostgreSQLIntegrationTester) TruncateTable(ctx context.Context, dbPool *pgxpool.Pool, tables []string) error {
-	return TruncatePostgreSQLTable(ctx, dbPool, tables)
+func (p *PostgreSQLIntegrationTester) TruncateTable(ctx context.Context, db PostgreSQLExecutor, tables []string) error {
+	return TruncatePostgreSQLTable(ctx, db, tables)
+}
+
+func (p *PostgreSQLIntegrationTester) FetchData(ctx context.Context, db PostgreSQLExecutor, query string, rowHandler func(rows pgx.Rows) (any, error)) ([]any, error) {
+	return FetchPostgreSQLData(ctx, db, query, rowHandler)
 }
 
-func (p *PostgreSQLIntegrationTester) FetchData(ctx context.Context, dbPool *pgxpool.Pool, query string, rowHandler func(rows pgx.Rows) (any, error)) ([]any, error) {
-	rows, err := dbPool.Query(ctx, query)
+func FetchPostgreSQLData(ctx context.Context, db PostgreSQLExecutor, query string, rowHandler func(rows pgx.Rows) (any, error)) ([]any, error) {
+	rows, err := db.Query(ctx, query)
 	if err != nil {
 		return nil, err
 	}
@@ -124,60 +323,95 @@ func (p *PostgreSQLIntegrationTester) FetchData(ctx context.Context, dbPool *pgx
 		result = append(result, x)
 	}
 
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
 	return result, nil
 }
 
-func InitPostgreSQLSchema(ctx context.Context, db *pgxpool.Pool, schema []string) error {
-	query := strings.Join(schema, ";\n")
+func scanRowValues(rows pgx.Rows) (any, error) {
+	return rows.Values()
+}
 
-	tx, err := db.BeginTx(ctx, pgx.TxOptions{})
-	defer func() {
-		_ = tx.Rollback(ctx)
-	}()
+// Generically scanned rows are positional, so their ignored columns are left out by index
+func ignoredColumnIndexes(ignored []string, columns []string) []string {
+	var indexes []string
 
-	if err != nil {
-		return err
+	for _, name := range ignored {
+		for i, column := range columns {
+			if normalizeColumnName(column) == normalizeColumnName(name) {
+				indexes = append(indexes, fmt.Sprintf("[%d]", i))
+			}
+		}
 	}
 
-	if _, err := tx.Conn().Exec(ctx, query); err != nil {
+	return indexes
+}
+
+func InitPostgreSQLSchema(ctx context.Context, db PostgreSQLExecutor, schema []string) error {
+	query := strings.Join(schema, ";\n")
+
+	return inPostgreSQLTx(ctx, db, func(tx PostgreSQLExecutor) error {
+		_, err := tx.Exec(ctx, query)
 		return err
-	}
+	})
+}
 
-	return tx.Commit(ctx)
+func TruncatePostgreSQLTable(ctx context.Context, db PostgreSQLExecutor, tables []string) error {
+	return inPostgreSQLTx(ctx, db, func(tx PostgreSQLExecutor) error {
+		_, err := tx.Exec(ctx, fmt.Sprintf(`TRUNCATE TABLE %s;`, strings.Join(tables, ", ")))
+		return err
+	})
 }
 
-func TruncatePostgreSQLTable(ctx context.Context, db *pgxpool.Pool, tables []string) error {
-	tx, err := db.BeginTx(ctx, pgx.TxOptions{})
-	defer func() {
-		_ = tx.Rollback(ctx)
-	}()
+func SeedPostgreSQLData(ctx context.Context, db PostgreSQLExecutor, query string, data [][]any) error {
+	_, err := seedPostgreSQLRows(ctx, db, query, data)
+	return err
+}
 
-	if err != nil {
-		return err
+// Run the query for each row of data in one transaction, returning the rows affected by all of them,
+// which may differ from the rows of data, e.g. with INSERT ... SELECT or ON CONFLICT DO NOTHING
+func seedPostgreSQLRows(ctx context.Context, db PostgreSQLExecutor, query string, data [][]any) (int64, error) {
+	var affected int64
+
+	if err := inPostgreSQLTx(ctx, db, func(tx PostgreSQLExecutor) error {
+		for _, v := range data {
+			tag, err := tx.Exec(ctx, query, v...)
+			if err != nil {
+				return err
+			}
+
+			affected += tag.RowsAffected()
+		}
+
+		return nil
+	}); err != nil {
+		return 0, err
 	}
 
-	if _, err := tx.Conn().Exec(ctx, fmt.Sprintf(`TRUNCATE TABLE %s;`, strings.Join(tables, ", "))); err != nil {
+	return affected, nil
+}
+
+// Run fn in a transaction of db, or in a savepoint when db is already a transaction.
+// Executors that cannot begin transactions run fn directly.
+func inPostgreSQLTx(ctx context.Context, db PostgreSQLExecutor, fn func(tx PostgreSQLExecutor) error) error {
+	tx, ok, err := beginPostgreSQLTx(ctx, db)
+	if err != nil {
 		return err
 	}
 
-	return tx.Commit(ctx)
-}
+	if !ok {
+		return fn(db)
+	}
 
-func SeedPostgreSQLData(ctx context.Context, db *pgxpool.Pool, query string, data [][]any) error {
-	tx, err := db.BeginTx(ctx, pgx.TxOptions{})
 	defer func() {
 		_ = tx.Rollback(ctx)
 	}()
 
-	if err != nil {
+	if err := fn(tx); err != nil {
 		return err
 	}
 
-	for _, v := range data {
-		if _, err := tx.Conn().Exec(ctx, query, v...); err != nil {
-			return err
-		}
-	}
-
 	return tx.Commit(ctx)
 }
@@ -0,0 +1,273 @@
+package sakerhet
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+)
+
+// Table the audit triggers of the change recorders write to
+const PostgreSQLRecordedChangesTable = "sakerhet_recorded_changes"
+
+const (
+	PostgreSQLInsert = "INSERT"
+	PostgreSQLUpdate = "UPDATE"
+	PostgreSQLDelete = "DELETE"
+)
+
+// Row change captured by a PostgreSQLChangeRecorder.
+// As an expectation of AssertChanges a nil Old or New is not compared,
+// otherwise only the columns it names are, with plain values or PostgreSQLValueMatcher values.
+type PostgreSQLChange struct {
+	// As the regclass text of the table, without schema for the tables on the search path
+	Table     string
+	Operation string
+	// Row before an UPDATE or DELETE and after an INSERT or UPDATE, with values of the column types
+	// as AssertTableRows gets them, e.g. time.Time for timestamps and int64 for bigints.
+	// Rows are typed after the current definition of the table, so columns added since are NULL
+	// and dropped ones are missing.
+	Old PostgreSQLTableRow
+	New PostgreSQLTableRow
+}
+
+// Records the row changes made to some tables through audit triggers, by any session.
+// TRUNCATE, as done by ResetDatabase, is not recorded.
+type PostgreSQLChangeRecorder struct {
+	db     PostgreSQLExecutor
+	tables []string
+	id     string
+}
+
+// Recorder of the INSERT, UPDATE and DELETE statements on the given tables, from Start until Stop
+func (p *PostgreSQLIntegrationTester) NewChangeRecorder(db PostgreSQLExecutor, tables ...string) *PostgreSQLChangeRecorder {
+	return &PostgreSQLChangeRecorder{
+		db:     db,
+		tables: tables,
+		id:     strings.ReplaceAll(uuid.NewString(), "-", "")[:16],
+	}
+}
+
+func (r *PostgreSQLChangeRecorder) triggerName() string {
+	return fmt.Sprintf("sakerhet_record_changes_%s", r.id)
+}
+
+// Install the audit triggers, forgetting the changes recorded before
+func (r *PostgreSQLChangeRecorder) Start(ctx context.Context) error {
+	return inPostgreSQLTx(ctx, r.db, func(tx PostgreSQLExecutor) error {
+		statements := []string{
+			fmt.Sprintf(`
+				CREATE TABLE IF NOT EXISTS %s (
+					id BIGSERIAL PRIMARY KEY,
+					recorder TEXT NOT NULL,
+					table_name TEXT NOT NULL,
+					operation TEXT NOT NULL,
+					old_row JSONB,
+					new_row JSONB
+				);
+			`, PostgreSQLRecordedChangesTable),
+			fmt.Sprintf(`
+				CREATE OR REPLACE FUNCTION sakerhet_record_change() RETURNS trigger LANGUAGE plpgsql AS $$
+				BEGIN
+					INSERT INTO %s (recorder, table_name, operation, old_row, new_row)
+					VALUES (
+						TG_ARGV[0],
+						TG_RELID::regclass::text,
+						TG_OP,
+						CASE WHEN TG_OP <> 'INSERT' THEN to_jsonb(OLD) END,
+						CASE WHEN TG_OP <> 'DELETE' THEN to_jsonb(NEW) END
+					);
+
+					RETURN NULL;
+				END
+				$$;
+			`, PostgreSQLRecordedChangesTable),
+		}
+
+		for _, table := range r.tables {
+			statements = append(statements, fmt.Sprintf(
+				`CREATE TRIGGER %s AFTER INSERT OR UPDATE OR DELETE ON %s FOR EACH ROW EXECUTE FUNCTION sakerhet_record_change('%s');`,
+				pgx.Identifier{r.triggerName()}.Sanitize(),
+				quoteQualifiedIdentifier(table),
+				r.id,
+			))
+		}
+
+		for _, statement := range statements {
+			if _, err := tx.Exec(ctx, statement); err != nil {
+				return fmt.Errorf("starting change recorder: %w", err)
+			}
+		}
+
+		_, err := tx.Exec(ctx, fmt.Sprintf(`DELETE FROM %s WHERE recorder = $1;`, PostgreSQLRecordedChangesTable), r.id)
+
+		return err
+	})
+}
+
+// Remove the audit triggers, the changes recorded so far stay available
+func (r *PostgreSQLChangeRecorder) Stop(ctx context.Context) error {
+	return inPostgreSQLTx(ctx, r.db, func(tx PostgreSQLExecutor) error {
+		for _, table := range r.tables {
+			if _, err := tx.Exec(ctx, fmt.Sprintf(
+				`DROP TRIGGER IF EXISTS %s ON %s;`,
+				pgx.Identifier{r.triggerName()}.Sanitize(),
+				quoteQualifiedIdentifier(table),
+			)); err != nil {
+				return fmt.Errorf("stopping change recorder: %w", err)
+			}
+		}
+
+		return nil
+	})
+}
+
+// The recorded changes, in the order they were made
+func (r *PostgreSQLChangeRecorder) Changes(ctx context.Context) ([]PostgreSQLChange, error) {
+	rows, err := r.db.Query(ctx, fmt.Sprintf(
+		`SELECT table_name, operation, old_row::text, new_row::text FROM %s WHERE recorder = $1 ORDER BY id;`,
+		PostgreSQLRecordedChangesTable,
+	), r.id)
+	if err != nil {
+		return nil, err
+	}
+
+	type recordedChange struct {
+		change         PostgreSQLChange
+		oldRow, newRow *string
+	}
+
+	var recorded []recordedChange
+
+	for rows.Next() {
+		var v recordedChange
+
+		if err := rows.Scan(&v.change.Table, &v.change.Operation, &v.oldRow, &v.newRow); err != nil {
+			rows.Close()
+			return nil, err
+		}
+
+		recorded = append(recorded, v)
+	}
+
+	rows.Close()
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	changes := make([]PostgreSQLChange, len(recorded))
+
+	for i, v := range recorded {
+		changes[i] = v.change
+
+		if changes[i].Old, err = decodeRecordedRow(ctx, r.db, v.change.Table, v.oldRow); err != nil {
+			return nil, err
+		}
+
+		if changes[i].New, err = decodeRecordedRow(ctx, r.db, v.change.Table, v.newRow); err != nil {
+			return nil, err
+		}
+	}
+
+	return changes, nil
+}
+
+// Turn the JSON of a recorded row back into a row of the table, so that its values keep their types
+func decodeRecordedRow(ctx context.Context, db PostgreSQLExecutor, table string, raw *string) (PostgreSQLTableRow, error) {
+	if raw == nil {
+		return nil, nil
+	}
+
+	rows, err := db.Query(ctx, fmt.Sprintf(`SELECT * FROM jsonb_populate_record(NULL::%s, $1::jsonb);`, quoteRegclass(table)), *raw)
+	if err != nil {
+		return nil, fmt.Errorf("decoding recorded row of %s: %w", table, err)
+	}
+
+	defer rows.Close()
+
+	if !rows.Next() {
+		if err := rows.Err(); err != nil {
+			return nil, fmt.Errorf("decoding recorded row of %s: %w", table, err)
+		}
+
+		return nil, fmt.Errorf("decoding recorded row of %s: no row", table)
+	}
+
+	values, err := rows.Values()
+	if err != nil {
+		return nil, fmt.Errorf("decoding recorded row of %s: %w", table, err)
+	}
+
+	row := make(PostgreSQLTableRow, len(values))
+	for i, fd := range rows.FieldDescriptions() {
+		row[fd.Name] = values[i]
+	}
+
+	return row, nil
+}
+
+// Assert that the recorded changes are exactly the expected ones, in any order
+func (r *PostgreSQLChangeRecorder) AssertChanges(ctx context.Context, expected []PostgreSQLChange) error {
+	changes, err := r.Changes(ctx)
+	if err != nil {
+		return err
+	}
+
+	matchedExpected, matchedReceived := matchByEquality(expected, changes, func(e, c PostgreSQLChange) bool {
+		return e.Table == c.Table && e.Operation == c.Operation && changeRowMatches(e.Old, c.Old) && changeRowMatches(e.New, c.New)
+	})
+
+	var sb strings.Builder
+
+	for i, v := range expected {
+		if !matchedExpected[i] {
+			fmt.Fprintf(&sb, "- %s on %s old=%s new=%s\n", v.Operation, v.Table, formatChangeRow(v.Old), formatChangeRow(v.New))
+		}
+	}
+
+	for i, v := range changes {
+		if !matchedReceived[i] {
+			fmt.Fprintf(&sb, "+ %s on %s old=%s new=%s\n", v.Operation, v.Table, formatChangeRow(v.Old), formatChangeRow(v.New))
+		}
+	}
+
+	if sb.Len() > 0 {
+		return fmt.Errorf("recorded changes are different than expected:\n%s", sb.String())
+	}
+
+	return nil
+}
+
+func changeRowMatches(expected, received PostgreSQLTableRow) bool {
+	if expected == nil {
+		return true
+	}
+
+	for column, v := range expected {
+		value, ok := received[column]
+		if !ok || !tableValueMatches(v, value) {
+			return false
+		}
+	}
+
+	return true
+}
+
+func formatChangeRow(row PostgreSQLTableRow) string {
+	if row == nil {
+		return "<none>"
+	}
+
+	columns := sortedRowColumns(row)
+
+	pairs := make([]string, len(columns))
+	for i, column := range columns {
+		pairs[i] = fmt.Sprintf("%s:%s", column, formatValue(reflect.ValueOf(row[column]), nil))
+	}
+
+	return fmt.Sprintf("{%s}", strings.Join(pairs, " "))
+}
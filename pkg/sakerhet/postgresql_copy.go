@@ -0,0 +1,67 @@
+package sakerhet
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// Implemented by *pgxpool.Pool, *pgx.Conn and pgx.Tx, which load rows with COPY FROM
+type PostgreSQLCopier interface {
+	CopyFrom(ctx context.Context, tableName pgx.Identifier, columnNames []string, rowSrc pgx.CopyFromSource) (int64, error)
+}
+
+// Bulk load rows into the columns of a table, returning how many rows were loaded
+func (p *PostgreSQLIntegrationTester) CopyData(ctx context.Context, db PostgreSQLExecutor, table string, columns []string, rows [][]any) (int64, error) {
+	return CopyPostgreSQLData(ctx, db, table, columns, rows)
+}
+
+// Load rows with COPY FROM, which pgx sends in the binary format, so each value must be encodable as its column type:
+// unlike with InsertQuery, a string such as "25" is not parsed by PostgreSQL into an integer column.
+// Executors without COPY support, such as database/sql ones, get the rows inserted one by one in a transaction.
+func CopyPostgreSQLData(ctx context.Context, db PostgreSQLExecutor, table string, columns []string, rows [][]any) (int64, error) {
+	if copier, ok := db.(PostgreSQLCopier); ok {
+		loaded, err := copier.CopyFrom(ctx, pgx.Identifier(strings.Split(table, ".")), columns, pgx.CopyFromRows(rows))
+		if err != nil {
+			return 0, fmt.Errorf("copying into %s: %w", table, err)
+		}
+
+		return loaded, nil
+	}
+
+	quoted := make([]string, len(columns))
+	placeholders := make([]string, len(columns))
+
+	for i, column := range columns {
+		quoted[i] = pgx.Identifier{column}.Sanitize()
+		placeholders[i] = fmt.Sprintf("$%d", i+1)
+	}
+
+	query := fmt.Sprintf(
+		`INSERT INTO %s (%s) VALUES (%s);`,
+		quoteQualifiedIdentifier(table),
+		strings.Join(quoted, ", "),
+		strings.Join(placeholders, ", "),
+	)
+
+	var loaded int64
+
+	if err := inPostgreSQLTx(ctx, db, func(tx PostgreSQLExecutor) error {
+		for _, v := range rows {
+			tag, err := tx.Exec(ctx, query, v...)
+			if err != nil {
+				return fmt.Errorf("inserting into %s: %w", table, err)
+			}
+
+			loaded += tag.RowsAffected()
+		}
+
+		return nil
+	}); err != nil {
+		return 0, err
+	}
+
+	return loaded, nil
+}
@@ -0,0 +1,111 @@
+package sakerhet
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+)
+
+type PostgreSQLEventuallyOptions struct {
+	// Wait before the second attempt, defaults to 100ms
+	Interval time.Duration
+	// Factor applied to the wait after each attempt, defaults to 1 for a constant interval
+	Backoff float64
+	// Upper bound of the wait between attempts, defaults to 5s
+	MaxInterval time.Duration
+	// Give up after this long, defaults to GetIntegrationTestTimeout(), an earlier context deadline still applies
+	Timeout time.Duration
+}
+
+// Expectations still not met when the polling gave up
+type PostgreSQLEventuallyError struct {
+	Attempts int
+	Elapsed  time.Duration
+	// Result of the last attempt
+	Last *PostgreSQLIntegrationTestReport
+}
+
+func (e *PostgreSQLEventuallyError) Error() string {
+	var sb strings.Builder
+
+	fmt.Fprintf(&sb, "expectations not met after %d attempts in %s, last attempt: %s", e.Attempts, e.Elapsed.Round(time.Millisecond), e.Last)
+
+	for _, f := range e.Last.Failures {
+		fmt.Fprintf(&sb, "last observed rows of %q:\n", strings.TrimSpace(f.Expectation.GetQuery))
+
+		for _, v := range f.Received {
+			fmt.Fprintf(&sb, "  %#v\n", v)
+		}
+	}
+
+	return sb.String()
+}
+
+func (e *PostgreSQLEventuallyError) Unwrap() error {
+	return e.Last
+}
+
+// Check the expectations until they are all met, for data written asynchronously
+func (p *PostgreSQLIntegrationTester) CheckEventually(ctx context.Context, db PostgreSQLExecutor, expects []PostgreSQLIntegrationTestExpectation, opts PostgreSQLEventuallyOptions) error {
+	return EventuallyPostgreSQLExpectations(ctx, db, expects, opts)
+}
+
+// Re-run the expectation queries, waiting longer between attempts when a backoff is given,
+// until they are all met or the timeout expires, returning a *PostgreSQLEventuallyError in the latter case.
+// Query errors are returned right away.
+func EventuallyPostgreSQLExpectations(ctx context.Context, db PostgreSQLExecutor, expects []PostgreSQLIntegrationTestExpectation, opts PostgreSQLEventuallyOptions) error {
+	if opts.Interval <= 0 {
+		opts.Interval = 100 * time.Millisecond
+	}
+
+	if opts.Backoff < 1 {
+		opts.Backoff = 1
+	}
+
+	if opts.MaxInterval <= 0 {
+		opts.MaxInterval = 5 * time.Second
+	}
+
+	if opts.Timeout <= 0 {
+		opts.Timeout = GetIntegrationTestTimeout()
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, opts.Timeout)
+	defer cancel()
+
+	start := time.Now()
+	wait := opts.Interval
+
+	var last *PostgreSQLIntegrationTestReport
+
+	for attempt := 1; ; attempt++ {
+		report, err := checkPostgreSQLExpectations(ctx, db, expects)
+
+		switch {
+		case err != nil && ctx.Err() != nil && last != nil:
+			// the deadline interrupted the query, the previous attempt is the last complete one
+			return &PostgreSQLEventuallyError{Attempts: attempt - 1, Elapsed: time.Since(start), Last: last}
+		case err != nil:
+			return err
+		case report == nil:
+			return nil
+		}
+
+		last = report
+
+		timer := time.NewTimer(wait)
+
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return &PostgreSQLEventuallyError{Attempts: attempt, Elapsed: time.Since(start), Last: last}
+		case <-timer.C:
+		}
+
+		wait = time.Duration(float64(wait) * opts.Backoff)
+		if wait > opts.MaxInterval {
+			wait = opts.MaxInterval
+		}
+	}
+}
@@ -0,0 +1,43 @@
+package sakerhet
+
+import (
+	"fmt"
+	"net"
+
+	abstractedcontainers "github.com/averageflow/sakerhet/pkg/abstracted_containers"
+)
+
+// Fault proxy in front of a PostgreSQL container
+type PostgreSQLFaultProxy struct {
+	*FaultProxy
+	// URL of the container database through the proxy, to give to the code under test
+	ConnectionURL string
+}
+
+// Start a fault proxy in front of the mapped port of the container, defaulting to the container of Start.
+// Close it once done, the container is left running.
+func (p *PostgreSQLIntegrationTester) NewFaultProxy(container *abstractedcontainers.PostgreSQLContainer) (*PostgreSQLFaultProxy, error) {
+	if container == nil {
+		container = p.Container
+	}
+
+	if container == nil {
+		return nil, fmt.Errorf("no PostgreSQL container to proxy, call Start first")
+	}
+
+	proxy, err := NewFaultProxy(net.JoinHostPort(container.Host, container.MappedPort))
+	if err != nil {
+		return nil, err
+	}
+
+	host, port, err := net.SplitHostPort(proxy.Addr())
+	if err != nil {
+		_ = proxy.Close()
+		return nil, err
+	}
+
+	return &PostgreSQLFaultProxy{
+		FaultProxy:    proxy,
+		ConnectionURL: container.ConnectionURLForAddr(host, port, container.DB),
+	}, nil
+}
@@ -0,0 +1,319 @@
+package sakerhet
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/jackc/pgx/v5"
+	"gopkg.in/yaml.v3"
+)
+
+// Value of a CSV fixture cell that is inserted as NULL, as in PostgreSQL COPY
+const PostgreSQLFixtureCSVNull = `\N`
+
+// Rows to insert, as column to value maps, by table name
+type PostgreSQLFixtures map[string][]map[string]any
+
+// Load fixture files into the database, see ReadPostgreSQLFixtures for the supported formats
+func (p *PostgreSQLIntegrationTester) LoadFixtures(ctx context.Context, db PostgreSQLExecutor, paths ...string) error {
+	fixtures, err := ReadPostgreSQLFixtures(paths...)
+	if err != nil {
+		return err
+	}
+
+	return LoadPostgreSQLFixtures(ctx, db, fixtures)
+}
+
+// Read fixture files, depending on their extension:
+//   - .yaml, .yml and .json files contain a table name to list of rows mapping
+//   - .csv files contain the rows of the table named as the file, with a header of column names
+func ReadPostgreSQLFixtures(paths ...string) (PostgreSQLFixtures, error) {
+	fixtures := make(PostgreSQLFixtures)
+
+	for _, path := range paths {
+		ext := strings.ToLower(filepath.Ext(path))
+
+		switch ext {
+		case ".yaml", ".yml", ".json", ".csv":
+		default:
+			return nil, fmt.Errorf("unsupported fixture format %q of %s", ext, path)
+		}
+
+		raw, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+
+		fileFixtures := make(PostgreSQLFixtures)
+
+		switch ext {
+		case ".yaml", ".yml":
+			err = yaml.Unmarshal(raw, &fileFixtures)
+		case ".json":
+			decoder := json.NewDecoder(bytes.NewReader(raw))
+			decoder.UseNumber()
+			err = decoder.Decode(&fileFixtures)
+		case ".csv":
+			table := strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+			fileFixtures[table], err = readCSVFixture(raw)
+		}
+
+		if err != nil {
+			return nil, fmt.Errorf("reading fixture %s: %w", path, err)
+		}
+
+		for table, rows := range fileFixtures {
+			fixtures[table] = append(fixtures[table], rows...)
+		}
+	}
+
+	return fixtures, nil
+}
+
+func readCSVFixture(raw []byte) ([]map[string]any, error) {
+	records, err := csv.NewReader(bytes.NewReader(raw)).ReadAll()
+	if err != nil {
+		return nil, err
+	}
+
+	if len(records) == 0 {
+		return nil, nil
+	}
+
+	header := records[0]
+
+	var rows []map[string]any
+
+	for _, record := range records[1:] {
+		row := make(map[string]any, len(header))
+
+		for i, column := range header {
+			if record[i] == PostgreSQLFixtureCSVNull {
+				row[column] = nil
+			} else {
+				row[column] = record[i]
+			}
+		}
+
+		rows = append(rows, row)
+	}
+
+	return rows, nil
+}
+
+// Insert the fixtures in a single transaction, tables referenced by foreign keys first,
+// then reset the sequences of the loaded tables past the inserted values
+func LoadPostgreSQLFixtures(ctx context.Context, db PostgreSQLExecutor, fixtures PostgreSQLFixtures) error {
+	return inPostgreSQLTx(ctx, db, func(tx PostgreSQLExecutor) error {
+		// resolve the names as PostgreSQL sees them, so they match the foreign key catalog
+		rowsByTable := make(map[string][]map[string]any, len(fixtures))
+
+		for table, rows := range fixtures {
+			var resolved string
+
+			if err := tx.QueryRow(ctx, `SELECT $1::regclass::text;`, table).Scan(&resolved); err != nil {
+				return fmt.Errorf("resolving fixture table %q: %w", table, err)
+			}
+
+			rowsByTable[resolved] = append(rowsByTable[resolved], rows...)
+		}
+
+		tables, err := sortTablesByForeignKeys(ctx, tx, rowsByTable)
+		if err != nil {
+			return err
+		}
+
+		for _, table := range tables {
+			for _, row := range rowsByTable[table] {
+				query, args := fixtureInsertQuery(table, row)
+
+				if _, err := tx.Exec(ctx, query, args...); err != nil {
+					return fmt.Errorf("inserting fixture into %s: %w", table, err)
+				}
+			}
+
+			if err := resetPostgreSQLSequences(ctx, tx, table); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+func quoteQualifiedIdentifier(name string) string {
+	return pgx.Identifier(strings.Split(name, ".")).Sanitize()
+}
+
+// The regclass text form of a table is already quoted where needed
+func quoteRegclass(name string) string {
+	if strings.Contains(name, `"`) {
+		return name
+	}
+
+	return quoteQualifiedIdentifier(name)
+}
+
+func fixtureInsertQuery(table string, row map[string]any) (string, []any) {
+	columns := make([]string, 0, len(row))
+	for column := range row {
+		columns = append(columns, column)
+	}
+
+	sort.Strings(columns)
+
+	quoted := make([]string, len(columns))
+	placeholders := make([]string, len(columns))
+	args := make([]any, len(columns))
+
+	for i, column := range columns {
+		quoted[i] = pgx.Identifier{column}.Sanitize()
+		placeholders[i] = fmt.Sprintf("$%d", i+1)
+		args[i] = row[column]
+	}
+
+	query := fmt.Sprintf(
+		`INSERT INTO %s (%s) VALUES (%s);`,
+		quoteRegclass(table),
+		strings.Join(quoted, ", "),
+		strings.Join(placeholders, ", "),
+	)
+
+	return query, args
+}
+
+// Order tables so that the ones referenced by foreign keys come before the ones referencing them
+func sortTablesByForeignKeys(ctx context.Context, tx PostgreSQLExecutor, tables map[string][]map[string]any) ([]string, error) {
+	rows, err := tx.Query(ctx, `
+		SELECT conrelid::regclass::text, confrelid::regclass::text
+		FROM pg_constraint
+		WHERE contype = 'f' AND conrelid <> confrelid;
+	`)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	dependencies := make(map[string]map[string]bool)
+
+	for rows.Next() {
+		var table, referenced string
+
+		if err := rows.Scan(&table, &referenced); err != nil {
+			return nil, err
+		}
+
+		if _, ok := tables[table]; !ok {
+			continue
+		}
+
+		if _, ok := tables[referenced]; !ok {
+			continue
+		}
+
+		if dependencies[table] == nil {
+			dependencies[table] = make(map[string]bool)
+		}
+
+		dependencies[table][referenced] = true
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	pending := make([]string, 0, len(tables))
+	for table := range tables {
+		pending = append(pending, table)
+	}
+
+	sort.Strings(pending)
+
+	var sorted []string
+
+	inserted := make(map[string]bool)
+
+	for len(pending) > 0 {
+		var blocked []string
+
+		for _, table := range pending {
+			ready := true
+
+			for referenced := range dependencies[table] {
+				if !inserted[referenced] {
+					ready = false
+					break
+				}
+			}
+
+			if ready {
+				sorted = append(sorted, table)
+				inserted[table] = true
+			} else {
+				blocked = append(blocked, table)
+			}
+		}
+
+		if len(blocked) == len(pending) {
+			return nil, fmt.Errorf("circular foreign keys between fixture tables %s", strings.Join(blocked, ", "))
+		}
+
+		pending = blocked
+	}
+
+	return sorted, nil
+}
+
+// Move the sequences backing serial and identity columns of the table past their highest value
+func resetPostgreSQLSequences(ctx context.Context, tx PostgreSQLExecutor, table string) error {
+	rows, err := tx.Query(ctx, `
+		SELECT attname, pg_get_serial_sequence($1::text, attname)
+		FROM pg_attribute
+		WHERE attrelid = $1::text::regclass AND attnum > 0 AND NOT attisdropped
+			AND pg_get_serial_sequence($1::text, attname) IS NOT NULL;
+	`, table)
+	if err != nil {
+		return err
+	}
+
+	sequences := make(map[string]string)
+
+	for rows.Next() {
+		var column, sequence string
+
+		if err := rows.Scan(&column, &sequence); err != nil {
+			rows.Close()
+			return err
+		}
+
+		sequences[column] = sequence
+	}
+
+	rows.Close()
+
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for column, sequence := range sequences {
+		query := fmt.Sprintf(
+			`SELECT setval($1, COALESCE(MAX(%[1]s), 1), MAX(%[1]s) IS NOT NULL) FROM %[2]s;`,
+			pgx.Identifier{column}.Sanitize(),
+			quoteRegclass(table),
+		)
+
+		if _, err := tx.Exec(ctx, query, sequence); err != nil {
+			return fmt.Errorf("resetting sequence %s: %w", sequence, err)
+		}
+	}
+
+	return nil
+}
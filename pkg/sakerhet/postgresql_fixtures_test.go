@@ -0,0 +1,47 @@
+package sakerhet_test
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/averageflow/sakerhet/pkg/sakerhet"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/suite"
+)
+
+type PostgreSQLFixturesTestSuite struct {
+	suite.Suite
+}
+
+func TestPostgreSQLFixturesTestSuite(t *testing.T) {
+	sakerhet.SkipUnitTestsWhenIntegrationTesting(t)
+	t.Parallel()
+	suite.Run(t, new(PostgreSQLFixturesTestSuite))
+}
+
+func (suite *PostgreSQLFixturesTestSuite) TestReadPostgreSQLFixtures() {
+	fixtures, err := sakerhet.ReadPostgreSQLFixtures(
+		"testdata/fixtures/accounts.yaml",
+		"testdata/fixtures/more_accounts.json",
+		"testdata/fixtures/posts.csv",
+	)
+	if err != nil {
+		suite.T().Fatal(err)
+	}
+
+	assert.Equal(suite.T(), sakerhet.PostgreSQLFixtures{
+		"accounts": {
+			{"user_id": 100, "username": "fixtureUser", "email": "fixture@example.com", "age": 30, "created_on": 1234567},
+			{"user_id": json.Number("101"), "username": "jsonUser", "email": "json@example.com", "age": json.Number("41"), "created_on": json.Number("7654321")},
+		},
+		"posts": {
+			{"user_id": "100", "title": "First post", "body": nil},
+			{"user_id": "100", "title": "Second post", "body": "Some words"},
+		},
+	}, fixtures)
+}
+
+func (suite *PostgreSQLFixturesTestSuite) TestReadPostgreSQLFixturesUnsupportedFormat() {
+	_, err := sakerhet.ReadPostgreSQLFixtures("testdata/fixtures/accounts.xml")
+	assert.ErrorContains(suite.T(), err, "unsupported fixture format")
+}
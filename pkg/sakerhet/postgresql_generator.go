@@ -0,0 +1,669 @@
+package sakerhet
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"math/rand"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+)
+
+type PostgreSQLGeneratorOptions struct {
+	// Same seed, schema and existing data give the same rows
+	Seed int64
+	// Values of some columns, by column name, called with the index of the generated row.
+	// Columns with a default are left to it unless given here.
+	Overrides map[string]func(r *rand.Rand, i int) any
+}
+
+// Generate n rows of random data for a table, as a seed for SeedData.
+// Values respect NOT NULL, UNIQUE and primary keys, the length of character and integer types,
+// and foreign keys, referencing rows already present in the referenced tables.
+// Composite keys get distinct combinations, unless one of their columns is overridden.
+// CHECK constraints and unique indexes on expressions or with a WHERE clause are not known to the generator,
+// use Overrides for the columns they restrict.
+func (p *PostgreSQLIntegrationTester) GenerateSeed(ctx context.Context, db PostgreSQLExecutor, table string, n int, opts PostgreSQLGeneratorOptions) (PostgreSQLIntegrationTestSeed, error) {
+	return GeneratePostgreSQLSeed(ctx, db, table, n, opts)
+}
+
+func GeneratePostgreSQLSeed(ctx context.Context, db PostgreSQLExecutor, table string, n int, opts PostgreSQLGeneratorOptions) (PostgreSQLIntegrationTestSeed, error) {
+	schema, err := InspectPostgreSQLTable(ctx, db, table)
+	if err != nil {
+		return PostgreSQLIntegrationTestSeed{}, err
+	}
+
+	kinds, err := generatedColumnKinds(ctx, db, table)
+	if err != nil {
+		return PostgreSQLIntegrationTestSeed{}, err
+	}
+
+	var keys [][]string
+
+	for _, c := range schema.Constraints {
+		if c.Type == PostgreSQLPrimaryKeyConstraint || c.Type == PostgreSQLUniqueConstraint {
+			keys = append(keys, c.Columns)
+		}
+	}
+
+	unmodelled, err := unmodelledUniqueIndexes(ctx, db, table)
+	if err != nil {
+		return PostgreSQLIntegrationTestSeed{}, err
+	}
+
+	for _, i := range schema.Indexes {
+		if i.Unique && !unmodelled[i.Name] {
+			keys = append(keys, i.Columns)
+		}
+	}
+
+	// columns unique on their own, the columns of composite keys only need distinct combinations
+	unique := make(map[string]bool)
+
+	for _, key := range keys {
+		if len(key) == 1 {
+			unique[key[0]] = true
+		}
+	}
+
+	r := rand.New(rand.NewSource(opts.Seed))
+
+	var generators []columnGenerator
+
+	// foreign key columns take whole tuples of the referenced rows
+	referenced := make(map[string]bool)
+
+	for _, c := range schema.Constraints {
+		if c.Type != PostgreSQLForeignKeyConstraint {
+			continue
+		}
+
+		g, err := foreignKeyGenerator(ctx, db, schema, c, unique)
+		if err != nil {
+			return PostgreSQLIntegrationTestSeed{}, err
+		}
+
+		for _, column := range c.Columns {
+			referenced[column] = true
+		}
+
+		generators = append(generators, g)
+	}
+
+	for _, c := range schema.Columns {
+		if referenced[c.Name] {
+			continue
+		}
+
+		if override, ok := opts.Overrides[c.Name]; ok {
+			generators = append(generators, columnGenerator{
+				columns:    []string{c.Name},
+				generate:   func(r *rand.Rand, i int) ([]any, error) { return []any{override(r, i)}, nil },
+				overridden: true,
+			})
+
+			continue
+		}
+
+		kind := kinds[c.Name]
+
+		if c.Default != nil || kind.skipped {
+			continue
+		}
+
+		g, err := valueGenerator(ctx, db, table, c, kind.enumLabels, unique[c.Name])
+		if err != nil {
+			return PostgreSQLIntegrationTestSeed{}, err
+		}
+
+		if !unique[c.Name] && g.domain == nil {
+			c := c
+
+			g.makeUnique = func() (columnGenerator, error) {
+				return valueGenerator(ctx, db, table, c, nil, true)
+			}
+		}
+
+		generators = append(generators, g)
+	}
+
+	for _, key := range keys {
+		if len(key) > 1 {
+			generators, err = distinctKeyGenerators(generators, key, unique)
+			if err != nil {
+				return PostgreSQLIntegrationTestSeed{}, err
+			}
+		}
+	}
+
+	var columns []string
+	for _, g := range generators {
+		columns = append(columns, g.columns...)
+	}
+
+	seed := PostgreSQLIntegrationTestSeed{InsertValues: make([][]any, n)}
+
+	if len(columns) == 0 {
+		seed.InsertQuery = fmt.Sprintf(`INSERT INTO %s DEFAULT VALUES;`, quoteQualifiedIdentifier(table))
+	} else {
+		quoted := make([]string, len(columns))
+		placeholders := make([]string, len(columns))
+
+		for i, column := range columns {
+			quoted[i] = pgx.Identifier{column}.Sanitize()
+			placeholders[i] = fmt.Sprintf("$%d", i+1)
+		}
+
+		seed.InsertQuery = fmt.Sprintf(
+			`INSERT INTO %s (%s) VALUES (%s);`,
+			quoteQualifiedIdentifier(table),
+			strings.Join(quoted, ", "),
+			strings.Join(placeholders, ", "),
+		)
+	}
+
+	for i := 0; i < n; i++ {
+		row := make([]any, 0, len(columns))
+
+		for _, g := range generators {
+			values, err := g.generate(r, i)
+			if err != nil {
+				return PostgreSQLIntegrationTestSeed{}, err
+			}
+
+			row = append(row, values...)
+		}
+
+		seed.InsertValues[i] = row
+	}
+
+	return seed, nil
+}
+
+type columnGenerator struct {
+	columns  []string
+	generate func(r *rand.Rand, i int) ([]any, error)
+	// Every possible value of the columns, when there are few of them
+	domain [][]any
+	// Generator of the same columns giving a different value for each row, when it can be built
+	makeUnique func() (columnGenerator, error)
+	overridden bool
+}
+
+// Make the generated values of a composite key distinct, by giving one of its columns a unique value per row,
+// or else by enumerating the combinations of the values its columns can take
+func distinctKeyGenerators(generators []columnGenerator, key []string, unique map[string]bool) ([]columnGenerator, error) {
+	var covering []int
+
+	for _, column := range key {
+		// a column unique on its own already makes the key distinct
+		if unique[column] {
+			return generators, nil
+		}
+
+		found := false
+
+		for i, g := range generators {
+			for _, v := range g.columns {
+				if v == column {
+					found = true
+
+					if !containsInt(covering, i) {
+						covering = append(covering, i)
+					}
+				}
+			}
+		}
+
+		// columns left to their defaults, such as serial ones, are not known to the generator
+		if !found {
+			return generators, nil
+		}
+	}
+
+	for _, i := range covering {
+		if generators[i].overridden {
+			return generators, nil
+		}
+	}
+
+	for _, i := range covering {
+		if generators[i].makeUnique != nil {
+			g, err := generators[i].makeUnique()
+			if err != nil {
+				return nil, err
+			}
+
+			generators[i] = g
+
+			return generators, nil
+		}
+	}
+
+	combined := columnGenerator{}
+	total := 1
+
+	var domains [][][]any
+
+	for _, i := range covering {
+		if len(generators[i].domain) == 0 {
+			return generators, nil
+		}
+
+		combined.columns = append(combined.columns, generators[i].columns...)
+		domains = append(domains, generators[i].domain)
+
+		if total <= math.MaxInt32 {
+			total *= len(generators[i].domain)
+		}
+	}
+
+	combined.generate = func(r *rand.Rand, i int) ([]any, error) {
+		if i >= total {
+			return nil, fmt.Errorf("unique key (%s) has only %d combinations", strings.Join(key, ", "), total)
+		}
+
+		var values []any
+
+		// the row index in mixed radix, one digit per generator
+		for _, domain := range domains {
+			values = append(values, domain[i%len(domain)]...)
+			i /= len(domain)
+		}
+
+		return values, nil
+	}
+
+	result := make([]columnGenerator, 0, len(generators))
+
+	for i, g := range generators {
+		switch {
+		case i == covering[0]:
+			result = append(result, combined)
+		case !containsInt(covering, i):
+			result = append(result, g)
+		}
+	}
+
+	return result, nil
+}
+
+func containsInt(values []int, v int) bool {
+	for _, x := range values {
+		if x == v {
+			return true
+		}
+	}
+
+	return false
+}
+
+type generatedColumnKind struct {
+	// identity and generated columns get their values from PostgreSQL
+	skipped    bool
+	enumLabels []string
+}
+
+func generatedColumnKinds(ctx context.Context, db PostgreSQLExecutor, table string) (map[string]generatedColumnKind, error) {
+	rows, err := db.Query(ctx, `
+		SELECT a.attname::text, a.attidentity <> '' OR a.attgenerated <> '',
+			ARRAY(SELECT e.enumlabel::text FROM pg_enum e WHERE e.enumtypid = a.atttypid ORDER BY e.enumsortorder)
+		FROM pg_attribute a
+		WHERE a.attrelid = $1::text::regclass AND a.attnum > 0 AND NOT a.attisdropped;
+	`, table)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	kinds := make(map[string]generatedColumnKind)
+
+	for rows.Next() {
+		var column string
+		var kind generatedColumnKind
+
+		if err := rows.Scan(&column, &kind.skipped, &kind.enumLabels); err != nil {
+			return nil, err
+		}
+
+		kinds[column] = kind
+	}
+
+	return kinds, rows.Err()
+}
+
+// Unique indexes on expressions or with a WHERE clause, whose columns as read by InspectTable
+// leave out the expressions or hold values unique only among some rows
+func unmodelledUniqueIndexes(ctx context.Context, db PostgreSQLExecutor, table string) (map[string]bool, error) {
+	rows, err := db.Query(ctx, `
+		SELECT c.relname::text
+		FROM pg_index i
+		JOIN pg_class c ON c.oid = i.indexrelid
+		WHERE i.indrelid = $1::text::regclass AND i.indisunique AND (i.indexprs IS NOT NULL OR i.indpred IS NOT NULL);
+	`, table)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	unmodelled := make(map[string]bool)
+
+	for rows.Next() {
+		var name string
+
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+
+		unmodelled[name] = true
+	}
+
+	return unmodelled, rows.Err()
+}
+
+// Pick the referencing columns among the rows of the referenced table
+func foreignKeyGenerator(ctx context.Context, db PostgreSQLExecutor, schema *PostgreSQLTableSchema, c PostgreSQLConstraint, unique map[string]bool) (columnGenerator, error) {
+	quoted := make([]string, len(c.ReferencedColumns))
+	for i, column := range c.ReferencedColumns {
+		quoted[i] = pgx.Identifier{column}.Sanitize()
+	}
+
+	rows, err := db.Query(ctx, fmt.Sprintf(
+		`SELECT DISTINCT %[1]s FROM %[2]s ORDER BY %[1]s;`,
+		strings.Join(quoted, ", "),
+		quoteRegclass(c.ReferencedTable),
+	))
+	if err != nil {
+		return columnGenerator{}, err
+	}
+
+	defer rows.Close()
+
+	var candidates [][]any
+
+	for rows.Next() {
+		values, err := rows.Values()
+		if err != nil {
+			return columnGenerator{}, err
+		}
+
+		candidates = append(candidates, values)
+	}
+
+	if err := rows.Err(); err != nil {
+		return columnGenerator{}, err
+	}
+
+	nullable := true
+	// a unique reference, as in one to one relations, takes each referenced row once
+	uniqueReference := true
+
+	for _, v := range c.Columns {
+		uniqueReference = uniqueReference && unique[v]
+	}
+
+	for _, column := range schema.Columns {
+		for _, v := range c.Columns {
+			if column.Name == v && !column.Nullable {
+				nullable = false
+			}
+		}
+	}
+
+	return columnGenerator{
+		columns: c.Columns,
+		domain:  candidates,
+		generate: func(r *rand.Rand, i int) ([]any, error) {
+			if len(candidates) == 0 {
+				if nullable {
+					return make([]any, len(c.Columns)), nil
+				}
+
+				return nil, fmt.Errorf("table %s referenced by %s has no rows, seed it first", c.ReferencedTable, c.Name)
+			}
+
+			if uniqueReference {
+				if i >= len(candidates) {
+					return nil, fmt.Errorf("unique reference %s has only %d rows of %s to use", c.Name, len(candidates), c.ReferencedTable)
+				}
+
+				return candidates[i], nil
+			}
+
+			return candidates[r.Intn(len(candidates))], nil
+		},
+	}, nil
+}
+
+var characterTypeLength = regexp.MustCompile(`^(?:character varying|character)\((\d+)\)$`)
+
+var numericPrecision = regexp.MustCompile(`^numeric\((\d+),(\d+)\)$`)
+
+// Random values of the column type, unique ones built around the row index
+func valueGenerator(ctx context.Context, db PostgreSQLExecutor, table string, c PostgreSQLColumn, enumLabels []string, unique bool) (columnGenerator, error) {
+	g := columnGenerator{columns: []string{c.Name}}
+
+	single := func(f func(r *rand.Rand, i int) (any, error)) columnGenerator {
+		g.generate = func(r *rand.Rand, i int) ([]any, error) {
+			v, err := f(r, i)
+			return []any{v}, err
+		}
+
+		return g
+	}
+
+	// unique values other than integers follow the rows already in the table,
+	// so that they differ from the ones generated for them
+	var existing int
+
+	if unique {
+		if err := db.QueryRow(ctx, fmt.Sprintf(`SELECT count(*)::int FROM %s;`, quoteQualifiedIdentifier(table))).Scan(&existing); err != nil {
+			return g, err
+		}
+	}
+
+	if len(enumLabels) > 0 {
+		if unique {
+			return single(func(r *rand.Rand, i int) (any, error) {
+				i += existing
+
+				if i >= len(enumLabels) {
+					return nil, fmt.Errorf("unique column %s has only %d values", c.Name, len(enumLabels))
+				}
+
+				return enumLabels[i], nil
+			}), nil
+		}
+
+		g = single(func(r *rand.Rand, i int) (any, error) {
+			return enumLabels[r.Intn(len(enumLabels))], nil
+		})
+
+		for _, v := range enumLabels {
+			g.domain = append(g.domain, []any{v})
+		}
+
+		return g, nil
+	}
+
+	switch c.DataType {
+	case "smallint", "integer", "bigint":
+		limit := int64(math.MaxInt16)
+		if c.DataType != "smallint" {
+			limit = 1000000
+		}
+
+		maxValue := map[string]int64{"smallint": math.MaxInt16, "integer": math.MaxInt32, "bigint": math.MaxInt64}[c.DataType]
+
+		if !unique {
+			return single(func(r *rand.Rand, i int) (any, error) {
+				return r.Int63n(limit), nil
+			}), nil
+		}
+
+		// start past the values already in the table
+		var start int64
+
+		if err := db.QueryRow(ctx, fmt.Sprintf(
+			`SELECT COALESCE(MAX(%s), 0)::bigint FROM %s;`,
+			pgx.Identifier{c.Name}.Sanitize(),
+			quoteQualifiedIdentifier(table),
+		)).Scan(&start); err != nil {
+			return g, err
+		}
+
+		if start < 0 {
+			start = 0
+		}
+
+		return single(func(r *rand.Rand, i int) (any, error) {
+			if int64(i) >= maxValue-start {
+				return nil, fmt.Errorf("unique column %s of type %s has no room for %d rows after %d", c.Name, c.DataType, i+1, start)
+			}
+
+			return start + int64(i) + 1, nil
+		}), nil
+	case "real", "double precision", "numeric":
+		return single(func(r *rand.Rand, i int) (any, error) {
+			if unique {
+				return float64(existing+i) + r.Float64(), nil
+			}
+
+			return math.Round(r.Float64()*100000) / 100, nil
+		}), nil
+	case "boolean":
+		if unique {
+			return g, fmt.Errorf("unique boolean column %s cannot be generated, use an override", c.Name)
+		}
+
+		g = single(func(r *rand.Rand, i int) (any, error) {
+			return r.Intn(2) == 1, nil
+		})
+		g.domain = [][]any{{false}, {true}}
+
+		return g, nil
+	case "text", "character varying", "citext":
+		return single(func(r *rand.Rand, i int) (any, error) {
+			return randomText(r, c.Name, existing+i, 0, unique)
+		}), nil
+	case "uuid":
+		return single(func(r *rand.Rand, i int) (any, error) {
+			id, err := uuid.NewRandomFromReader(r)
+			if err != nil || !unique {
+				return id.String(), err
+			}
+
+			// the same seed draws the same uuids, told apart by the row index
+			return uuid.NewSHA1(id, []byte(strconv.Itoa(existing+i))).String(), nil
+		}), nil
+	case "date", "timestamp without time zone", "timestamp with time zone":
+		return single(func(r *rand.Rand, i int) (any, error) {
+			start := time.Date(2020, time.January, 1, 0, 0, 0, 0, time.UTC)
+			t := start.Add(time.Duration(r.Int63n(int64(5 * 365 * 24 * time.Hour))))
+
+			if unique {
+				t = start.Add(time.Duration(existing+i) * 24 * time.Hour)
+			}
+
+			if c.DataType == "date" {
+				t = t.Truncate(24 * time.Hour)
+			} else {
+				t = t.Truncate(time.Second)
+			}
+
+			return t, nil
+		}), nil
+	case "json", "jsonb":
+		return single(func(r *rand.Rand, i int) (any, error) {
+			return fmt.Sprintf(`{"%s": %d}`, c.Name, r.Intn(1000)+i*1000), nil
+		}), nil
+	case "bytea":
+		return single(func(r *rand.Rand, i int) (any, error) {
+			b := make([]byte, 16)
+			_, _ = r.Read(b)
+
+			if unique {
+				b = append(b, []byte(strconv.Itoa(existing+i))...)
+			}
+
+			return b, nil
+		}), nil
+	}
+
+	if match := characterTypeLength.FindStringSubmatch(c.DataType); match != nil {
+		length, _ := strconv.Atoi(match[1])
+
+		return single(func(r *rand.Rand, i int) (any, error) {
+			return randomText(r, c.Name, existing+i, length, unique)
+		}), nil
+	}
+
+	if match := numericPrecision.FindStringSubmatch(c.DataType); match != nil {
+		precision, _ := strconv.Atoi(match[1])
+		scale, _ := strconv.Atoi(match[2])
+		limit := math.Pow10(precision - scale)
+
+		return single(func(r *rand.Rand, i int) (any, error) {
+			if unique {
+				if float64(existing+i) >= limit {
+					return nil, fmt.Errorf("unique column %s of type %s has no room for %d rows", c.Name, c.DataType, existing+i+1)
+				}
+
+				return float64(existing + i), nil
+			}
+
+			return math.Floor(r.Float64()*limit*math.Pow10(scale)) / math.Pow10(scale), nil
+		}), nil
+	}
+
+	if c.Nullable {
+		return single(func(r *rand.Rand, i int) (any, error) {
+			return nil, nil
+		}), nil
+	}
+
+	return g, fmt.Errorf("cannot generate values of type %s for column %s, use an override", c.DataType, c.Name)
+}
+
+const randomTextLetters = "abcdefghijklmnopqrstuvwxyz"
+
+// Text starting with the column name when it fits, made unique by the base 36 row index
+func randomText(r *rand.Rand, column string, i int, length int, unique bool) (string, error) {
+	var sb strings.Builder
+
+	sb.WriteString(column)
+	sb.WriteString("_")
+
+	for j := 0; j < 8; j++ {
+		sb.WriteByte(randomTextLetters[r.Intn(len(randomTextLetters))])
+	}
+
+	text := sb.String()
+
+	if unique {
+		suffix := "_" + strconv.FormatInt(int64(i), 36)
+
+		if length > 0 && len(suffix) > length {
+			return "", fmt.Errorf("unique column %s of length %d has no room for %d rows", column, length, i+1)
+		}
+
+		if length > 0 && len(text)+len(suffix) > length {
+			text = text[len(text)+len(suffix)-length:]
+		}
+
+		return text + suffix, nil
+	}
+
+	if length > 0 && len(text) > length {
+		text = text[len(text)-length:]
+	}
+
+	return text, nil
+}
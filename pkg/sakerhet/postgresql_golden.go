@@ -0,0 +1,147 @@
+package sakerhet
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+// Directory holding the golden files, relative to the package under test
+const PostgreSQLGoldenFilesDir = "testdata"
+
+// Compare the result of a query with the golden file testdata/<name>.golden,
+// which is written instead when ShouldUpdateGoldenFiles is true.
+// Rows are compared in the order returned, so the query should have an ORDER BY.
+func (p *PostgreSQLIntegrationTester) AssertGoldenQuery(ctx context.Context, db PostgreSQLExecutor, name string, query string, args ...any) error {
+	return AssertPostgreSQLGoldenQuery(ctx, db, name, query, args...)
+}
+
+func AssertPostgreSQLGoldenQuery(ctx context.Context, db PostgreSQLExecutor, name string, query string, args ...any) error {
+	received, err := SerializePostgreSQLQueryResult(ctx, db, query, args...)
+	if err != nil {
+		return err
+	}
+
+	path := filepath.Join(PostgreSQLGoldenFilesDir, name+".golden")
+
+	if ShouldUpdateGoldenFiles() {
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			return err
+		}
+
+		return os.WriteFile(path, received, 0o644)
+	}
+
+	expected, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("reading golden file, set %s to create it: %w", SakerhetUpdateGoldenFilesEnvVar, err)
+	}
+
+	if bytes.Equal(expected, received) {
+		return nil
+	}
+
+	expectedLines := strings.Split(string(expected), "\n")
+	receivedLines := strings.Split(string(received), "\n")
+
+	line := 0
+	for line < len(expectedLines) && line < len(receivedLines) && expectedLines[line] == receivedLines[line] {
+		line++
+	}
+
+	return fmt.Errorf(
+		"query result differs from golden file %s at line %d:\n expected %q\n received %q\nset %s to update it, full result:\n%s",
+		path,
+		line+1,
+		lineOrEnd(expectedLines, line),
+		lineOrEnd(receivedLines, line),
+		SakerhetUpdateGoldenFilesEnvVar,
+		received,
+	)
+}
+
+func lineOrEnd(lines []string, i int) string {
+	if i < len(lines) {
+		return lines[i]
+	}
+
+	return "<end of file>"
+}
+
+// Deterministic text form of a query result: the column names and types, then one tab separated line per row
+func SerializePostgreSQLQueryResult(ctx context.Context, db PostgreSQLExecutor, query string, args ...any) ([]byte, error) {
+	rows, err := db.Query(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	var buf bytes.Buffer
+
+	typeMap := pgtype.NewMap()
+
+	buf.WriteString("-- columns\n")
+
+	for _, fd := range rows.FieldDescriptions() {
+		typeName := fmt.Sprintf("oid:%d", fd.DataTypeOID)
+		if t, ok := typeMap.TypeForOID(fd.DataTypeOID); ok {
+			typeName = t.Name
+		}
+
+		fmt.Fprintf(&buf, "%s %s\n", fd.Name, typeName)
+	}
+
+	buf.WriteString("-- rows\n")
+
+	for rows.Next() {
+		values, err := rows.Values()
+		if err != nil {
+			return nil, err
+		}
+
+		formatted := make([]string, len(values))
+		for i, v := range values {
+			formatted[i] = formatGoldenValue(v)
+		}
+
+		buf.WriteString(strings.Join(formatted, "\t"))
+		buf.WriteString("\n")
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// Strings are quoted, so that they cannot be mistaken for NULL or other values
+func formatGoldenValue(v any) string {
+	switch x := v.(type) {
+	case nil:
+		return "NULL"
+	case string:
+		return strconv.Quote(x)
+	case []byte:
+		return `\x` + hex.EncodeToString(x)
+	case time.Time:
+		return x.UTC().Format(time.RFC3339Nano)
+	case pgtype.Numeric:
+		if raw, err := x.Value(); err == nil && raw != nil {
+			return fmt.Sprintf("%v", raw)
+		}
+	case [16]byte:
+		return fmt.Sprintf("%x-%x-%x-%x-%x", x[0:4], x[4:6], x[6:8], x[8:10], x[10:16])
+	}
+
+	return fmt.Sprintf("%v", v)
+}
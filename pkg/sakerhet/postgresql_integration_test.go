@@ -2,14 +2,22 @@ package sakerhet_test
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"math"
+	"math/rand"
+	"os"
+	"strings"
 	"testing"
+	"testing/fstest"
+	"time"
 
 	abstractedcontainers "github.com/averageflow/sakerhet/pkg/abstracted_containers"
 	"github.com/averageflow/sakerhet/pkg/sakerhet"
 	"github.com/google/uuid"
 	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/suite"
 )
 
@@ -28,24 +36,19 @@ func (suite *PostgreSQLTestSuite) SetupSuite() {
 	ctx := context.Background()
 
 	suite.IntegrationTester = sakerhet.NewSakerhetIntegrationTest(sakerhet.SakerhetBuilder{
-		PostgreSQL: &sakerhet.PostgreSQLIntegrationTestParams{},
+		PostgreSQL: &sakerhet.PostgreSQLIntegrationTestParams{
+			PoolSettings:  sakerhet.PostgreSQLPoolSettings{MaxConns: 8},
+			RecordQueries: true,
+		},
 	})
 
-	// Spin up one PostgreSQL container for all the tests in the suite
-	postgreSQLC, err := suite.IntegrationTester.PostgreSQLIntegrationTester.ContainerStart(ctx)
-	if err != nil {
+	// Spin up one PostgreSQL container for all the tests in the suite, with a DB pool that will be reused across tests
+	if err := suite.IntegrationTester.PostgreSQLIntegrationTester.Start(ctx); err != nil {
 		suite.T().Fatal(err)
 	}
 
-	suite.PostgreSQLContainer = postgreSQLC
-
-	// Create DB pool that will be reused across tests
-	dbpool, err := pgxpool.New(ctx, suite.PostgreSQLContainer.ConnectionURL)
-	if err != nil {
-		suite.T().Fatal(fmt.Errorf("Unable to create connection pool: %v\n", err))
-	}
-
-	suite.DBPool = dbpool
+	suite.PostgreSQLContainer = suite.IntegrationTester.PostgreSQLIntegrationTester.Container
+	suite.DBPool = suite.IntegrationTester.PostgreSQLIntegrationTester.Pool
 
 	// Setup schema that will be reused across tests
 	initialSchema := []string{
@@ -56,6 +59,14 @@ func (suite *PostgreSQLTestSuite) SetupSuite() {
 				email VARCHAR ( 255 ) UNIQUE NOT NULL,
 				age INTEGER NOT NULL,
 	      created_on INTEGER NOT NULL DEFAULT extract(epoch from now())
+    );
+		`,
+		`
+		CREATE TABLE posts (
+				post_id serial PRIMARY KEY,
+				user_id INTEGER NOT NULL REFERENCES accounts (user_id),
+				title VARCHAR ( 255 ) NOT NULL,
+				body TEXT
     );
 		`,
 	}
@@ -74,10 +85,10 @@ func (suite *PostgreSQLTestSuite) SetupTest() {
 
 // After each test
 func (suite *PostgreSQLTestSuite) TearDownTest() {
-	if err := suite.IntegrationTester.PostgreSQLIntegrationTester.TruncateTable(
+	if err := suite.IntegrationTester.PostgreSQLIntegrationTester.ResetDatabase(
 		context.Background(),
 		suite.DBPool,
-		[]string{"accounts"},
+		sakerhet.PostgreSQLResetOptions{},
 	); err != nil {
 		suite.T().Fatal(err)
 	}
@@ -85,8 +96,9 @@ func (suite *PostgreSQLTestSuite) TearDownTest() {
 
 // After suite ends
 func (suite *PostgreSQLTestSuite) TearDownSuite() {
-	suite.DBPool.Close()
-	_ = suite.PostgreSQLContainer.Terminate(context.Background())
+	if err := suite.IntegrationTester.PostgreSQLIntegrationTester.Terminate(context.Background()); err != nil {
+		suite.T().Error(err)
+	}
 }
 
 // Start the test suite if we are running integration tests
@@ -152,100 +164,1665 @@ func (suite *PostgreSQLTestSuite) TestHighLevelIntegrationTestPostgreSQL() {
 	}
 }
 
-// Low level test with full control on testing code that uses PostgreSQL
-func TestLowLevelIntegrationTestPostgreSQL(t *testing.T) {
-	sakerhet.SkipIntegrationTestsWhenUnitTesting(t)
+// High level test running a situation with a custom row handler
+func (suite *PostgreSQLTestSuite) TestHighLevelIntegrationTestPostgreSQLRun() {
+	type account struct {
+		userId    int
+		username  string
+		email     string
+		age       int
+		createdOn int
+	}
 
-	// given
-	password := fmt.Sprintf("password-%s", uuid.NewString())
-	user := fmt.Sprintf("user-%s", uuid.NewString())
-	db := fmt.Sprintf("db-%s", uuid.NewString())
+	rowHandler := func(rows pgx.Rows) (any, error) {
+		var acc account
 
-	postgreSQLC, err := abstractedcontainers.SetupPostgreSQL(context.Background(), user, password, db)
+		if err := rows.Scan(&acc.userId, &acc.username, &acc.email, &acc.age, &acc.createdOn); err != nil {
+			return nil, err
+		}
+
+		return acc, nil
+	}
+
+	situation := sakerhet.PostgreSQLIntegrationTestSituation{
+		Seeds: []sakerhet.PostgreSQLIntegrationTestSeed{
+			{
+				InsertQuery: `INSERT INTO accounts (username, email, age, created_on) VALUES ($1, $2, $3, $4);`,
+				InsertValues: [][]any{
+					{"myUser", "myEmail", 25, 1234567},
+					{"mySecondUser", "mySecondEmail", 50, 999999},
+				},
+			},
+		},
+		Expects: []sakerhet.PostgreSQLIntegrationTestExpectation{
+			{
+				GetQuery: `SELECT user_id, username, email, age, created_on FROM accounts;`,
+				ExpectedValues: []any{
+					account{userId: 2, username: "mySecondUser", email: "mySecondEmail", age: 50, createdOn: 999999},
+					account{userId: 1, username: "myUser", email: "myEmail", age: 25, createdOn: 1234567},
+				},
+				RowHandler: rowHandler,
+				// generated IDs are left out of the comparison
+				IgnoreFields: []string{"user_id"},
+			},
+		},
+	}
+
+	if err := situation.Run(suite.TestContext, suite.DBPool); err != nil {
+		suite.T().Fatal(err)
+	}
+}
+
+// High level test of an action under test, with rows scanned generically
+func (suite *PostgreSQLTestSuite) TestHighLevelIntegrationTestPostgreSQLWithAction() {
+	situation := sakerhet.PostgreSQLIntegrationTestSituation{
+		Seeds: []sakerhet.PostgreSQLIntegrationTestSeed{
+			{
+				InsertQuery: `INSERT INTO accounts (username, email, age, created_on) VALUES ($1, $2, $3, $4);`,
+				InsertValues: [][]any{
+					{"myUser", "myEmail", 25, 1234567},
+				},
+			},
+		},
+		Action: func(ctx context.Context, db sakerhet.PostgreSQLExecutor) error {
+			_, err := db.Exec(ctx, `UPDATE accounts SET age = age + 1 WHERE username = $1;`, "myUser")
+			return err
+		},
+		Expects: []sakerhet.PostgreSQLIntegrationTestExpectation{
+			{
+				GetQuery:       `SELECT username, age FROM accounts;`,
+				ExpectedValues: []any{[]any{"myUser", 26}},
+			},
+		},
+	}
+
+	if err := situation.Run(suite.TestContext, suite.DBPool); err != nil {
+		suite.T().Fatal(err)
+	}
+}
+
+// High level test ignoring generated columns of rows scanned generically
+func (suite *PostgreSQLTestSuite) TestHighLevelIntegrationTestPostgreSQLIgnoreColumns() {
+	situation := sakerhet.PostgreSQLIntegrationTestSituation{
+		Seeds: []sakerhet.PostgreSQLIntegrationTestSeed{
+			{
+				InsertQuery:  `INSERT INTO accounts (username, email, age) VALUES ($1, $2, $3);`,
+				InsertValues: [][]any{{"myUser", "myEmail", 25}, {"mySecondUser", "mySecondEmail", 50}},
+			},
+		},
+		Expects: []sakerhet.PostgreSQLIntegrationTestExpectation{
+			{
+				GetQuery: `SELECT user_id, username, age FROM accounts;`,
+				// the generated IDs are not compared, whatever value stands in for them
+				ExpectedValues: []any{[]any{nil, "mySecondUser", 50}, []any{nil, "myUser", 25}},
+				IgnoreFields:   []string{"user_id"},
+			},
+		},
+	}
+
+	if err := situation.Run(suite.TestContext, suite.DBPool); err != nil {
+		suite.T().Fatal(err)
+	}
+
+	situation.Seeds = nil
+	situation.Expects[0].IgnoreFields = nil
+
+	var report *sakerhet.PostgreSQLIntegrationTestReport
+	if err := situation.Run(suite.TestContext, suite.DBPool); !errors.As(err, &report) {
+		suite.T().Fatalf("expected a report of the compared IDs, got %v", err)
+	}
+}
+
+// High level test with rows scanned into typed values
+func (suite *PostgreSQLTestSuite) TestHighLevelIntegrationTestPostgreSQLTypedRows() {
+	type account struct {
+		Name      string `db:"username"`
+		Email     *string
+		Age       int
+		CreatedOn int
+	}
+
+	seeds := []sakerhet.PostgreSQLIntegrationTestSeed{
+		{
+			InsertQuery: `INSERT INTO accounts (username, email, age, created_on) VALUES ($1, $2, $3, $4);`,
+			InsertValues: [][]any{
+				{"myUser", "myEmail", 25, 1234567},
+			},
+		},
+	}
+
+	if err := suite.IntegrationTester.PostgreSQLIntegrationTester.SeedData(suite.TestContext, suite.DBPool, seeds); err != nil {
+		suite.T().Fatal(err)
+	}
+
+	got, err := sakerhet.FetchInto[account](
+		suite.TestContext,
+		suite.DBPool,
+		`SELECT username, email, age, created_on FROM accounts WHERE age > $1;`,
+		18,
+	)
 	if err != nil {
-		t.Fatal(err)
+		suite.T().Fatal(err)
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), sakerhet.GetIntegrationTestTimeout())
-	defer cancel()
+	email := "myEmail"
+	expected := []account{
+		{Name: "myUser", Email: &email, Age: 25, CreatedOn: 1234567},
+	}
 
-	// clean up the container after the test is complete
-	defer func() {
-		_ = postgreSQLC.Terminate(context.Background())
-	}()
+	suite.Equal(expected, got)
 
-	dbpool, err := pgxpool.New(ctx, postgreSQLC.ConnectionURL)
+	usernames, err := sakerhet.FetchInto[string](suite.TestContext, suite.DBPool, `SELECT username FROM accounts;`)
 	if err != nil {
-		t.Fatal(fmt.Errorf("Unable to create connection pool: %v\n", err))
+		suite.T().Fatal(err)
 	}
 
-	defer dbpool.Close()
+	suite.Equal([]string{"myUser"}, usernames)
+}
 
-	initialSchema := []string{
-		`
-		CREATE TABLE accounts (
-				user_id serial PRIMARY KEY,
-				username VARCHAR ( 50 ) UNIQUE NOT NULL,
-				email VARCHAR ( 255 ) UNIQUE NOT NULL,
-				age INTEGER NOT NULL,
-	      created_on INTEGER NOT NULL DEFAULT extract(epoch from now())
-    );
-		`,
+// High level test seeding from fixture files
+func (suite *PostgreSQLTestSuite) TestHighLevelIntegrationTestPostgreSQLFixtures() {
+	if err := suite.IntegrationTester.PostgreSQLIntegrationTester.LoadFixtures(
+		suite.TestContext,
+		suite.DBPool,
+		"testdata/fixtures/posts.csv",
+		"testdata/fixtures/accounts.yaml",
+		"testdata/fixtures/more_accounts.json",
+	); err != nil {
+		suite.T().Fatal(err)
 	}
 
-	if err := sakerhet.InitPostgreSQLSchema(ctx, dbpool, initialSchema); err != nil {
-		t.Fatal(err)
+	type post struct {
+		UserID int
+		Title  string
+		Body   *string
 	}
 
-	insertQuery := `INSERT INTO accounts (username, email, age, created_on) VALUES ($1, $2, $3, $4);`
-	seedData := [][]any{
-		{"myUser", "myEmail", 25, 1234567},
+	posts, err := sakerhet.FetchInto[post](suite.TestContext, suite.DBPool, `SELECT user_id, title, body FROM posts;`)
+	if err != nil {
+		suite.T().Fatal(err)
 	}
 
-	// when
-	if err := sakerhet.SeedPostgreSQLData(ctx, dbpool, insertQuery, seedData); err != nil {
-		t.Fatal(err)
+	body := "Some words"
+	if diff := sakerhet.UnorderedDiff([]post{
+		{UserID: 100, Title: "First post"},
+		{UserID: 100, Title: "Second post", Body: &body},
+	}, posts); !diff.Equal() {
+		suite.T().Fatal(diff)
 	}
 
-	// then
-	type account struct {
-		userId    int
-		username  string
-		email     string
-		age       int
-		createdOn int
+	// sequences continue after the highest loaded value
+	var userID int
+	if err := suite.DBPool.QueryRow(
+		suite.TestContext,
+		`INSERT INTO accounts (username, email, age) VALUES ('newUser', 'newEmail', 20) RETURNING user_id;`,
+	).Scan(&userID); err != nil {
+		suite.T().Fatal(err)
 	}
 
-	getQuery := `SELECT user_id, username, email, age, created_on FROM accounts;`
+	suite.Equal(102, userID)
+}
 
-	rows, err := dbpool.Query(ctx, getQuery)
-	if err != nil {
-		t.Fatal(err)
+// High level test applying and reverting versioned migrations
+func (suite *PostgreSQLTestSuite) TestHighLevelIntegrationTestPostgreSQLMigrations() {
+	tester := suite.IntegrationTester.PostgreSQLIntegrationTester
+	migrations := os.DirFS("testdata/migrations")
+
+	if err := tester.MigrateUp(suite.TestContext, suite.DBPool, migrations, "."); err != nil {
+		suite.T().Fatal(err)
 	}
 
-	defer rows.Close()
+	// applying again is a no-op
+	if err := tester.MigrateUp(suite.TestContext, suite.DBPool, migrations, "."); err != nil {
+		suite.T().Fatal(err)
+	}
 
-	var result []account
+	var country string
+	if err := suite.DBPool.QueryRow(
+		suite.TestContext,
+		`INSERT INTO authors (name) VALUES ('  Astrid  ') RETURNING country;`,
+	).Scan(&country); err != nil {
+		suite.T().Fatal(err)
+	}
 
-	for rows.Next() {
-		var acc account
+	suite.Equal("SE", country)
 
-		if err := rows.Scan(&acc.userId, &acc.username, &acc.email, &acc.age, &acc.createdOn); err != nil {
-			t.Fatal(err)
+	// versions are tracked per migration set
+	reviews := fstest.MapFS{
+		"reviews/0001_create_reviews.up.sql":   {Data: []byte(`CREATE TABLE reviews (id INT);`)},
+		"reviews/0001_create_reviews.down.sql": {Data: []byte(`DROP TABLE reviews;`)},
+	}
+
+	if err := tester.MigrateUp(suite.TestContext, suite.DBPool, reviews, "reviews"); err != nil {
+		suite.T().Fatal(err)
+	}
+
+	if err := tester.MigrateDown(suite.TestContext, suite.DBPool, reviews, "reviews", 1); err != nil {
+		suite.T().Fatal(err)
+	}
+
+	if err := tester.MigrateDown(suite.TestContext, suite.DBPool, migrations, ".", 2); err != nil {
+		suite.T().Fatal(err)
+	}
+
+	var exists bool
+	if err := suite.DBPool.QueryRow(suite.TestContext, `SELECT to_regclass('authors') IS NOT NULL;`).Scan(&exists); err != nil {
+		suite.T().Fatal(err)
+	}
+
+	suite.False(exists)
+
+	broken := fstest.MapFS{
+		"broken/0001_broken.sql": {Data: []byte(`CREATE TABLE broken (id INT); SELECT * FROM missing_table;`)},
+	}
+
+	err := tester.MigrateUp(suite.TestContext, suite.DBPool, broken, "broken")
+
+	var migrationErr *sakerhet.PostgreSQLMigrationError
+	if suite.ErrorAs(err, &migrationErr) {
+		suite.Equal("broken/0001_broken.sql", migrationErr.File)
+		suite.Equal(2, migrationErr.StatementIndex)
+	}
+}
+
+// High level test of parallel tests isolated in their own transactions
+func (suite *PostgreSQLTestSuite) TestHighLevelIntegrationTestPostgreSQLIsolatedTx() {
+	tester := suite.IntegrationTester.PostgreSQLIntegrationTester
+
+	suite.T().Run("parallel", func(t *testing.T) {
+		for _, username := range []string{"firstUser", "secondUser"} {
+			username := username
+
+			t.Run(username, func(t *testing.T) {
+				t.Parallel()
+
+				tx := tester.IsolatedTx(suite.TestContext, t, suite.DBPool)
+
+				seeds := []sakerhet.PostgreSQLIntegrationTestSeed{
+					{
+						InsertQuery:  `INSERT INTO accounts (username, email, age) VALUES ($1, $2, $3);`,
+						InsertValues: [][]any{{username, username + "@example.com", 30}},
+					},
+				}
+
+				if err := tester.SeedData(suite.TestContext, tx, seeds); err != nil {
+					t.Fatal(err)
+				}
+
+				got, err := sakerhet.FetchInto[string](suite.TestContext, tx, `SELECT username FROM accounts;`)
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				// only the rows of this test are visible
+				if diff := sakerhet.UnorderedDiff([]string{username}, got); !diff.Equal() {
+					t.Fatal(diff)
+				}
+			})
 		}
+	})
 
-		result = append(result, acc)
+	var count int
+	if err := suite.DBPool.QueryRow(suite.TestContext, `SELECT count(*) FROM accounts;`).Scan(&count); err != nil {
+		suite.T().Fatal(err)
 	}
 
-	expected := []account{
-		{userId: 1, username: "myUser", email: "myEmail", age: 25, createdOn: 1234567},
+	suite.Equal(0, count)
+}
+
+// High level test of per test databases cloned from a template
+func (suite *PostgreSQLTestSuite) TestHighLevelIntegrationTestPostgreSQLTemplateDatabase() {
+	tester := suite.IntegrationTester.PostgreSQLIntegrationTester
+	template := fmt.Sprintf("template_%d", time.Now().UnixNano())
+
+	if err := tester.CreateTemplateDatabase(
+		suite.TestContext,
+		suite.PostgreSQLContainer,
+		template,
+		func(ctx context.Context, db sakerhet.PostgreSQLExecutor) error {
+			return sakerhet.InitPostgreSQLSchema(ctx, db, []string{`CREATE TABLE books (title TEXT NOT NULL);`})
+		},
+	); err != nil {
+		suite.T().Fatal(err)
+	}
+
+	defer func() {
+		_ = sakerhet.DropPostgreSQLDatabase(context.Background(), suite.PostgreSQLContainer, template)
+	}()
+
+	suite.T().Run("parallel", func(t *testing.T) {
+		for _, column := range []string{"author", "isbn"} {
+			column := column
+
+			t.Run(column, func(t *testing.T) {
+				t.Parallel()
+
+				db := tester.NewDatabaseFromTemplate(suite.TestContext, t, suite.PostgreSQLContainer, template)
+
+				// DDL changes do not leak into other tests
+				if _, err := db.Exec(suite.TestContext, fmt.Sprintf(`ALTER TABLE books ADD COLUMN %s TEXT;`, column)); err != nil {
+					t.Fatal(err)
+				}
+
+				got, err := sakerhet.FetchInto[string](
+					suite.TestContext,
+					db,
+					`SELECT column_name::text FROM information_schema.columns WHERE table_name = 'books';`,
+				)
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				if diff := sakerhet.UnorderedDiff([]string{"title", column}, got); !diff.Equal() {
+					t.Fatal(diff)
+				}
+			})
+		}
+	})
+}
+
+// High level test of a template database whose setup fails
+func (suite *PostgreSQLTestSuite) TestHighLevelIntegrationTestPostgreSQLTemplateDatabaseSetupFails() {
+	tester := suite.IntegrationTester.PostgreSQLIntegrationTester
+	template := fmt.Sprintf("template_%d", time.Now().UnixNano())
+
+	failingSetup := func(ctx context.Context, db sakerhet.PostgreSQLExecutor) error {
+		return sakerhet.InitPostgreSQLSchema(ctx, db, []string{`CREATE TABLE books (title TEXT NOT NULL, title TEXT);`})
+	}
+
+	err := tester.CreateTemplateDatabase(suite.TestContext, suite.PostgreSQLContainer, template, failingSetup)
+	suite.ErrorContains(err, "setting up template database")
+
+	var exists bool
+	if err := suite.DBPool.QueryRow(
+		suite.TestContext,
+		`SELECT EXISTS (SELECT 1 FROM pg_database WHERE datname = $1);`,
+		template,
+	).Scan(&exists); err != nil {
+		suite.T().Fatal(err)
+	}
+
+	suite.False(exists)
+
+	// the name is free again once the setup is fixed
+	if err := tester.CreateTemplateDatabase(
+		suite.TestContext,
+		suite.PostgreSQLContainer,
+		template,
+		func(ctx context.Context, db sakerhet.PostgreSQLExecutor) error {
+			return sakerhet.InitPostgreSQLSchema(ctx, db, []string{`CREATE TABLE books (title TEXT NOT NULL);`})
+		},
+	); err != nil {
+		suite.T().Fatal(err)
+	}
+
+	_ = sakerhet.DropPostgreSQLDatabase(context.Background(), suite.PostgreSQLContainer, template)
+}
+
+// High level test of resetting all tables
+func (suite *PostgreSQLTestSuite) TestHighLevelIntegrationTestPostgreSQLResetDatabase() {
+	tester := suite.IntegrationTester.PostgreSQLIntegrationTester
+
+	seeds := []sakerhet.PostgreSQLIntegrationTestSeed{
+		{
+			InsertQuery:  `INSERT INTO accounts (username, email, age) VALUES ($1, $2, $3);`,
+			InsertValues: [][]any{{"myUser", "myEmail", 25}},
+		},
+		{
+			InsertQuery:  `INSERT INTO posts (user_id, title) SELECT user_id, $1 FROM accounts;`,
+			InsertValues: [][]any{{"My post"}},
+		},
+	}
+
+	if err := tester.SeedData(suite.TestContext, suite.DBPool, seeds); err != nil {
+		suite.T().Fatal(err)
+	}
+
+	// keeping a table that references a truncated one would leave its rows dangling
+	err := tester.ResetDatabase(suite.TestContext, suite.DBPool, sakerhet.PostgreSQLResetOptions{
+		Schemas:       []string{"public"},
+		ExcludeTables: []string{"public.posts"},
+	})
+	suite.ErrorContains(err, "public.posts references public.accounts")
+
+	if err := tester.ResetDatabase(suite.TestContext, suite.DBPool, sakerhet.PostgreSQLResetOptions{
+		Schemas:       []string{"public"},
+		ExcludeTables: []string{"accounts"},
+	}); err != nil {
+		suite.T().Fatal(err)
+	}
+
+	var accounts, posts int
+	if err := suite.DBPool.QueryRow(
+		suite.TestContext,
+		`SELECT (SELECT count(*) FROM accounts), (SELECT count(*) FROM posts);`,
+	).Scan(&accounts, &posts); err != nil {
+		suite.T().Fatal(err)
 	}
 
-	if !sakerhet.UnorderedEqual(result, expected) {
-		t.Fatal(fmt.Errorf(
-			"received data is different than expected:\n received %v\n expected %v\n",
-			result,
-			expected,
-		))
+	suite.Equal(1, accounts)
+	suite.Equal(0, posts)
+
+	if err := tester.SeedData(suite.TestContext, suite.DBPool, seeds[1:]); err != nil {
+		suite.T().Fatal(err)
 	}
+
+	// cascading empties the referencing table, even though it is excluded
+	if err := tester.ResetDatabase(suite.TestContext, suite.DBPool, sakerhet.PostgreSQLResetOptions{
+		ExcludeTables: []string{"posts"},
+		Cascade:       true,
+	}); err != nil {
+		suite.T().Fatal(err)
+	}
+
+	if err := suite.DBPool.QueryRow(
+		suite.TestContext,
+		`SELECT (SELECT count(*) FROM accounts), (SELECT count(*) FROM posts);`,
+	).Scan(&accounts, &posts); err != nil {
+		suite.T().Fatal(err)
+	}
+
+	suite.Equal(0, accounts)
+	suite.Equal(0, posts)
+
+	if err := tester.ResetDatabase(suite.TestContext, suite.DBPool, sakerhet.PostgreSQLResetOptions{}); err != nil {
+		suite.T().Fatal(err)
+	}
+
+	if err := tester.SeedData(suite.TestContext, suite.DBPool, seeds[:1]); err != nil {
+		suite.T().Fatal(err)
+	}
+
+	ids, err := sakerhet.FetchInto[int](suite.TestContext, suite.DBPool, `SELECT user_id FROM accounts;`)
+	if err != nil {
+		suite.T().Fatal(err)
+	}
+
+	suite.Equal([]int{1}, ids)
+}
+
+// High level test asserting table contents without writing queries
+func (suite *PostgreSQLTestSuite) TestHighLevelIntegrationTestPostgreSQLAssertTableRows() {
+	tester := suite.IntegrationTester.PostgreSQLIntegrationTester
+
+	seeds := []sakerhet.PostgreSQLIntegrationTestSeed{
+		{
+			InsertQuery: `INSERT INTO accounts (username, email, age) VALUES ($1, $2, $3);`,
+			InsertValues: [][]any{
+				{"myUser", "myEmail", 25},
+				{"mySecondUser", "mySecondEmail", 50},
+			},
+		},
+	}
+
+	if err := tester.SeedData(suite.TestContext, suite.DBPool, seeds); err != nil {
+		suite.T().Fatal(err)
+	}
+
+	if err := tester.AssertTableRows(suite.TestContext, suite.DBPool, "accounts", []sakerhet.PostgreSQLTableRow{
+		{"username": "mySecondUser", "age": 50, "created_on": sakerhet.AnyNonNull()},
+		{"username": "myUser", "age": 25, "created_on": sakerhet.AnyNonNull()},
+	}, sakerhet.PostgreSQLTableAssertOptions{}); err != nil {
+		suite.T().Fatal(err)
+	}
+
+	if err := tester.AssertTableRows(suite.TestContext, suite.DBPool, "accounts", []sakerhet.PostgreSQLTableRow{
+		{"username": "myUser", "email": "myEmail", "user_id": 1},
+		{"username": "mySecondUser", "email": "mySecondEmail", "user_id": 2},
+	}, sakerhet.PostgreSQLTableAssertOptions{
+		Ordered:       true,
+		OrderBy:       []string{"username"},
+		IgnoreColumns: []string{"user_id"},
+	}); err == nil {
+		suite.T().Fatal("expected the ordered comparison to fail")
+	} else {
+		suite.Contains(err.Error(), "-1 | myEmail")
+	}
+}
+
+// High level test asserting exact values of bigint, numeric and real columns
+func (suite *PostgreSQLTestSuite) TestHighLevelIntegrationTestPostgreSQLAssertTableRowsExactNumbers() {
+	tester := suite.IntegrationTester.PostgreSQLIntegrationTester
+
+	if _, err := suite.DBPool.Exec(suite.TestContext, `CREATE TABLE ledger (id BIGINT NOT NULL, amount NUMERIC(12, 2) NOT NULL, ratio REAL NOT NULL);`); err != nil {
+		suite.T().Fatal(err)
+	}
+
+	defer func() {
+		_, _ = suite.DBPool.Exec(context.Background(), `DROP TABLE ledger;`)
+	}()
+
+	if _, err := suite.DBPool.Exec(suite.TestContext, `INSERT INTO ledger VALUES (9007199254740993, 1.50, 0.1);`); err != nil {
+		suite.T().Fatal(err)
+	}
+
+	if err := tester.AssertTableRows(suite.TestContext, suite.DBPool, "ledger", []sakerhet.PostgreSQLTableRow{
+		{"id": int64(9007199254740993), "amount": "1.5", "ratio": 0.1},
+	}, sakerhet.PostgreSQLTableAssertOptions{}); err != nil {
+		suite.T().Fatal(err)
+	}
+
+	// equal once converted to float64
+	suite.Error(tester.AssertTableRows(suite.TestContext, suite.DBPool, "ledger", []sakerhet.PostgreSQLTableRow{
+		{"id": int64(9007199254740992), "amount": "1.5", "ratio": 0.1},
+	}, sakerhet.PostgreSQLTableAssertOptions{}))
+
+	suite.Error(tester.AssertTableRows(suite.TestContext, suite.DBPool, "ledger", []sakerhet.PostgreSQLTableRow{
+		{"id": int64(9007199254740993), "amount": "1.500000000000000001", "ratio": 0.1},
+	}, sakerhet.PostgreSQLTableAssertOptions{}))
+}
+
+// High level test comparing a query result with a golden file
+func (suite *PostgreSQLTestSuite) TestHighLevelIntegrationTestPostgreSQLGoldenQuery() {
+	tester := suite.IntegrationTester.PostgreSQLIntegrationTester
+
+	seeds := []sakerhet.PostgreSQLIntegrationTestSeed{
+		{
+			InsertQuery: `INSERT INTO accounts (username, email, age) VALUES ($1, $2, $3);`,
+			InsertValues: [][]any{
+				{"myUser", "myEmail", 25},
+				{"mySecondUser", "mySecondEmail", 50},
+			},
+		},
+	}
+
+	if err := tester.SeedData(suite.TestContext, suite.DBPool, seeds); err != nil {
+		suite.T().Fatal(err)
+	}
+
+	if err := tester.AssertGoldenQuery(
+		suite.TestContext,
+		suite.DBPool,
+		"accounts_by_username",
+		`SELECT username, age, email IS NULL AS no_email FROM accounts WHERE age > $1 ORDER BY username;`,
+		18,
+	); err != nil {
+		suite.T().Fatal(err)
+	}
+}
+
+// High level test verifying the columns, indexes and constraints of a table
+func (suite *PostgreSQLTestSuite) TestHighLevelIntegrationTestPostgreSQLTableSchema() {
+	tester := suite.IntegrationTester.PostgreSQLIntegrationTester
+
+	defaultPostID := "nextval('posts_post_id_seq'::regclass)"
+
+	expected := sakerhet.PostgreSQLTableSchema{
+		Name: "posts",
+		Columns: []sakerhet.PostgreSQLColumn{
+			{Name: "post_id", DataType: "integer", Default: &defaultPostID},
+			{Name: "user_id", DataType: "integer"},
+			{Name: "title", DataType: "character varying(255)"},
+			{Name: "body", DataType: "text", Nullable: true},
+		},
+		Indexes: []sakerhet.PostgreSQLIndex{
+			{Name: "posts_pkey", Columns: []string{"post_id"}, Unique: true, Primary: true},
+		},
+		Constraints: []sakerhet.PostgreSQLConstraint{
+			{Name: "posts_pkey", Type: sakerhet.PostgreSQLPrimaryKeyConstraint, Columns: []string{"post_id"}},
+			{
+				Name:              "posts_user_id_fkey",
+				Type:              sakerhet.PostgreSQLForeignKeyConstraint,
+				Columns:           []string{"user_id"},
+				ReferencedTable:   "accounts",
+				ReferencedColumns: []string{"user_id"},
+			},
+		},
+	}
+
+	if err := tester.AssertTableSchema(suite.TestContext, suite.DBPool, expected); err != nil {
+		suite.T().Fatal(err)
+	}
+
+	expected.Columns[3].Nullable = false
+	expected.Indexes = append(expected.Indexes, sakerhet.PostgreSQLIndex{Name: "posts_title_idx", Columns: []string{"title"}})
+
+	err := tester.AssertTableSchema(suite.TestContext, suite.DBPool, expected)
+	if err == nil {
+		suite.T().Fatal("expected the schema assertion to fail")
+	}
+
+	suite.Contains(err.Error(), "column body: expected nullable false, got true")
+	suite.Contains(err.Error(), "index posts_title_idx is missing")
+
+	if _, err := tester.InspectTable(suite.TestContext, suite.DBPool, "missing_table"); err == nil {
+		suite.T().Fatal("expected inspecting a missing table to fail")
+	}
+}
+
+// High level test asserting the plan of a query
+func (suite *PostgreSQLTestSuite) TestHighLevelIntegrationTestPostgreSQLQueryPlan() {
+	tester := suite.IntegrationTester.PostgreSQLIntegrationTester
+
+	query := `SELECT user_id FROM accounts WHERE username = $1;`
+
+	if err := tester.AssertPlan(suite.TestContext, suite.DBPool, sakerhet.PostgreSQLPlanExpectation{
+		Query: query,
+		Args:  []any{"myUser"},
+		Options: sakerhet.PostgreSQLExplainOptions{
+			Analyze:  true,
+			Settings: map[string]string{"enable_seqscan": "off"},
+		},
+		Assertions: []sakerhet.PostgreSQLPlanAssertion{
+			sakerhet.UsesIndex("accounts_username_key"),
+			sakerhet.NoSeqScan("accounts"),
+			sakerhet.CostBelow(1000),
+		},
+	}); err != nil {
+		suite.T().Fatal(err)
+	}
+
+	err := tester.AssertPlan(suite.TestContext, suite.DBPool, sakerhet.PostgreSQLPlanExpectation{
+		Query:      `SELECT user_id FROM accounts WHERE age > 18;`,
+		Assertions: []sakerhet.PostgreSQLPlanAssertion{sakerhet.NoSeqScan("accounts")},
+	})
+	if err == nil {
+		suite.T().Fatal("expected the plan assertion to fail")
+	}
+
+	suite.Contains(err.Error(), "Seq Scan on accounts")
+
+	// analyzing a statement that changes data leaves no trace
+	if _, err := tester.ExplainQuery(
+		suite.TestContext,
+		suite.DBPool,
+		sakerhet.PostgreSQLExplainOptions{Analyze: true},
+		`INSERT INTO accounts (username, email, age) VALUES ($1, $2, $3);`,
+		"myUser", "myEmail", 25,
+	); err != nil {
+		suite.T().Fatal(err)
+	}
+
+	if err := tester.AssertTableRows(suite.TestContext, suite.DBPool, "accounts", []sakerhet.PostgreSQLTableRow{}, sakerhet.PostgreSQLTableAssertOptions{}); err != nil {
+		suite.T().Fatal(err)
+	}
+}
+
+// High level test using the pool and the database/sql handle owned by the tester
+func (suite *PostgreSQLTestSuite) TestHighLevelIntegrationTestPostgreSQLOwnedConnections() {
+	tester := suite.IntegrationTester.PostgreSQLIntegrationTester
+
+	suite.Equal(int32(8), tester.Pool.Config().MaxConns)
+
+	if _, err := tester.SQLDB.ExecContext(
+		suite.TestContext,
+		`INSERT INTO accounts (username, email, age) VALUES ($1, $2, $3);`,
+		"myUser", "myEmail", 25,
+	); err != nil {
+		suite.T().Fatal(err)
+	}
+
+	if err := tester.AssertTableRows(suite.TestContext, tester.Pool, "accounts", []sakerhet.PostgreSQLTableRow{
+		{"username": "myUser", "age": 25},
+	}, sakerhet.PostgreSQLTableAssertOptions{}); err != nil {
+		suite.T().Fatal(err)
+	}
+}
+
+// High level test using the helpers through database/sql
+func (suite *PostgreSQLTestSuite) TestHighLevelIntegrationTestPostgreSQLDatabaseSQL() {
+	tester := suite.IntegrationTester.PostgreSQLIntegrationTester
+	db := sakerhet.FromSQL(tester.SQLDB)
+
+	seeds := []sakerhet.PostgreSQLIntegrationTestSeed{
+		{
+			InsertQuery: `INSERT INTO accounts (username, email, age) VALUES ($1, $2, $3);`,
+			InsertValues: [][]any{
+				{"myUser", "myEmail", 25},
+				{"mySecondUser", "mySecondEmail", 50},
+			},
+		},
+	}
+
+	if err := tester.SeedData(suite.TestContext, db, seeds); err != nil {
+		suite.T().Fatal(err)
+	}
+
+	type account struct {
+		Username string
+		Age      int
+	}
+
+	accounts, err := sakerhet.FetchInto[account](suite.TestContext, db, `SELECT username, age FROM accounts ORDER BY age;`)
+	if err != nil {
+		suite.T().Fatal(err)
+	}
+
+	suite.Equal([]account{{Username: "myUser", Age: 25}, {Username: "mySecondUser", Age: 50}}, accounts)
+
+	if err := tester.AssertTableRows(suite.TestContext, db, "accounts", []sakerhet.PostgreSQLTableRow{
+		{"username": "myUser", "age": 25},
+		{"username": "mySecondUser", "age": 50},
+	}, sakerhet.PostgreSQLTableAssertOptions{}); err != nil {
+		suite.T().Fatal(err)
+	}
+
+	// a failing transaction leaves nothing behind
+	if err := tester.SeedData(suite.TestContext, db, []sakerhet.PostgreSQLIntegrationTestSeed{
+		{
+			InsertQuery:  `INSERT INTO accounts (username, email, age) VALUES ($1, $2, $3);`,
+			InsertValues: [][]any{{"myThirdUser", "myThirdEmail", 75}, {"myUser", "myEmail", 25}},
+		},
+	}); err == nil {
+		suite.T().Fatal("expected seeding a duplicate account to fail")
+	}
+
+	var count int
+
+	if err := db.QueryRow(suite.TestContext, `SELECT count(*) FROM accounts;`).Scan(&count); err != nil {
+		suite.T().Fatal(err)
+	}
+
+	suite.Equal(2, count)
+
+	// within a *sql.Tx a failing helper rolls back to a savepoint, leaving the transaction usable
+	tx, err := tester.SQLDB.BeginTx(suite.TestContext, nil)
+	if err != nil {
+		suite.T().Fatal(err)
+	}
+
+	defer func() {
+		_ = tx.Rollback()
+	}()
+
+	if err := tester.SeedData(suite.TestContext, sakerhet.FromSQL(tx), []sakerhet.PostgreSQLIntegrationTestSeed{
+		{
+			InsertQuery:  `INSERT INTO accounts (username, email, age) VALUES ($1, $2, $3);`,
+			InsertValues: [][]any{{"myThirdUser", "myThirdEmail", 75}, {"myUser", "myEmail", 25}},
+		},
+	}); err == nil {
+		suite.T().Fatal("expected seeding a duplicate account to fail")
+	}
+
+	if err := tx.QueryRowContext(suite.TestContext, `SELECT count(*) FROM accounts;`).Scan(&count); err != nil {
+		suite.T().Fatal(err)
+	}
+
+	suite.Equal(2, count)
+
+	tag, err := sakerhet.FromSQL(tx).Exec(suite.TestContext, `UPDATE accounts SET age = age + 1;`)
+	if err != nil {
+		suite.T().Fatal(err)
+	}
+
+	suite.Equal(int64(2), tag.RowsAffected())
+
+	if err := tx.Rollback(); err != nil {
+		suite.T().Fatal(err)
+	}
+
+	schema, err := tester.InspectTable(suite.TestContext, db, "accounts")
+	if err != nil {
+		suite.T().Fatal(err)
+	}
+
+	suite.Len(schema.Columns, 5)
+
+	if err := db.QueryRow(suite.TestContext, `SELECT 1 WHERE false;`).Scan(&count); !errors.Is(err, pgx.ErrNoRows) {
+		suite.T().Fatalf("expected %v, got %v", pgx.ErrNoRows, err)
+	}
+
+	if err := tester.ResetDatabase(suite.TestContext, db, sakerhet.PostgreSQLResetOptions{}); err != nil {
+		suite.T().Fatal(err)
+	}
+}
+
+// High level test bulk loading seed data with COPY
+func (suite *PostgreSQLTestSuite) TestHighLevelIntegrationTestPostgreSQLCopySeed() {
+	tester := suite.IntegrationTester.PostgreSQLIntegrationTester
+
+	rows := make([][]any, 10000)
+	for i := range rows {
+		rows[i] = []any{fmt.Sprintf("user%d", i), fmt.Sprintf("email%d", i), int32(i % 100)}
+	}
+
+	loaded, err := tester.SeedDataCount(suite.TestContext, suite.DBPool, []sakerhet.PostgreSQLIntegrationTestSeed{
+		{
+			CopyTable:    "accounts",
+			CopyColumns:  []string{"username", "email", "age"},
+			InsertValues: rows,
+		},
+		{
+			InsertQuery:  `INSERT INTO accounts (username, email, age) VALUES ($1, $2, $3);`,
+			InsertValues: [][]any{{"myUser", "myEmail", 25}},
+		},
+	})
+	if err != nil {
+		suite.T().Fatal(err)
+	}
+
+	suite.Equal(int64(10001), loaded)
+
+	// rows skipped by the query are not counted
+	loaded, err = tester.SeedDataCount(suite.TestContext, suite.DBPool, []sakerhet.PostgreSQLIntegrationTestSeed{
+		{
+			InsertQuery:  `INSERT INTO accounts (username, email, age) VALUES ($1, $2, $3) ON CONFLICT DO NOTHING;`,
+			InsertValues: [][]any{{"myUser", "myEmail", 25}},
+		},
+	})
+	if err != nil {
+		suite.T().Fatal(err)
+	}
+
+	suite.Equal(int64(0), loaded)
+
+	// database/sql executors fall back to inserts
+	loaded, err = tester.CopyData(
+		suite.TestContext,
+		sakerhet.FromSQL(tester.SQLDB),
+		"public.accounts",
+		[]string{"username", "email", "age"},
+		[][]any{{"mySecondUser", "mySecondEmail", 50}},
+	)
+	if err != nil {
+		suite.T().Fatal(err)
+	}
+
+	suite.Equal(int64(1), loaded)
+
+	var count int
+
+	if err := suite.DBPool.QueryRow(suite.TestContext, `SELECT count(*) FROM accounts;`).Scan(&count); err != nil {
+		suite.T().Fatal(err)
+	}
+
+	suite.Equal(10002, count)
+}
+
+// High level test seeding generated rows
+func (suite *PostgreSQLTestSuite) TestHighLevelIntegrationTestPostgreSQLGeneratedSeed() {
+	tester := suite.IntegrationTester.PostgreSQLIntegrationTester
+
+	opts := sakerhet.PostgreSQLGeneratorOptions{
+		Seed: 42,
+		Overrides: map[string]func(r *rand.Rand, i int) any{
+			"age": func(r *rand.Rand, i int) any { return 18 + r.Intn(80) },
+		},
+	}
+
+	// posts need accounts to reference
+	if _, err := tester.GenerateSeed(suite.TestContext, suite.DBPool, "posts", 10, opts); err == nil {
+		suite.T().Fatal("expected generating posts without accounts to fail")
+	}
+
+	accounts, err := tester.GenerateSeed(suite.TestContext, suite.DBPool, "accounts", 50, opts)
+	if err != nil {
+		suite.T().Fatal(err)
+	}
+
+	again, err := tester.GenerateSeed(suite.TestContext, suite.DBPool, "accounts", 50, opts)
+	if err != nil {
+		suite.T().Fatal(err)
+	}
+
+	suite.Equal(accounts, again)
+
+	if err := tester.SeedData(suite.TestContext, suite.DBPool, []sakerhet.PostgreSQLIntegrationTestSeed{accounts}); err != nil {
+		suite.T().Fatal(err)
+	}
+
+	posts, err := tester.GenerateSeed(suite.TestContext, suite.DBPool, "posts", 200, opts)
+	if err != nil {
+		suite.T().Fatal(err)
+	}
+
+	if err := tester.SeedData(suite.TestContext, suite.DBPool, []sakerhet.PostgreSQLIntegrationTestSeed{posts}); err != nil {
+		suite.T().Fatal(err)
+	}
+
+	var accountCount, postCount, authorCount int
+
+	if err := suite.DBPool.QueryRow(suite.TestContext, `
+		SELECT (SELECT count(*) FROM accounts), count(*), count(DISTINCT user_id) FROM posts;
+	`).Scan(&accountCount, &postCount, &authorCount); err != nil {
+		suite.T().Fatal(err)
+	}
+
+	suite.Equal(50, accountCount)
+	suite.Equal(200, postCount)
+	suite.LessOrEqual(authorCount, 50)
+
+	// unique text columns follow the rows already seeded with the same seed
+	more, err := tester.GenerateSeed(suite.TestContext, suite.DBPool, "accounts", 50, opts)
+	if err != nil {
+		suite.T().Fatal(err)
+	}
+
+	if err := tester.SeedData(suite.TestContext, suite.DBPool, []sakerhet.PostgreSQLIntegrationTestSeed{more}); err != nil {
+		suite.T().Fatal(err)
+	}
+
+	// the expression of the index is not known to the generator, which must not take kind as unique on its own
+	for _, statement := range []string{
+		`CREATE TABLE labels (kind BOOLEAN NOT NULL, name TEXT NOT NULL);`,
+		`CREATE UNIQUE INDEX labels_kind_name_idx ON labels (kind, lower(name));`,
+		`CREATE UNIQUE INDEX labels_kind_idx ON labels (kind) WHERE kind;`,
+	} {
+		if _, err := suite.DBPool.Exec(suite.TestContext, statement); err != nil {
+			suite.T().Fatal(err)
+		}
+	}
+
+	defer func() {
+		_, _ = suite.DBPool.Exec(context.Background(), `DROP TABLE labels;`)
+	}()
+
+	opts.Overrides["kind"] = func(r *rand.Rand, i int) any { return false }
+
+	labels, err := tester.GenerateSeed(suite.TestContext, suite.DBPool, "labels", 10, opts)
+	if err != nil {
+		suite.T().Fatal(err)
+	}
+
+	delete(opts.Overrides, "kind")
+
+	if err := tester.SeedData(suite.TestContext, suite.DBPool, []sakerhet.PostgreSQLIntegrationTestSeed{labels}); err != nil {
+		suite.T().Fatal(err)
+	}
+
+	// composite keys take distinct combinations, integers stay within their type
+	if _, err := suite.DBPool.Exec(suite.TestContext, `
+		CREATE TABLE account_flags (
+			user_id INTEGER NOT NULL REFERENCES accounts (user_id),
+			flag BOOLEAN NOT NULL,
+			position SMALLINT NOT NULL UNIQUE,
+			PRIMARY KEY (user_id, flag)
+		);
+	`); err != nil {
+		suite.T().Fatal(err)
+	}
+
+	defer func() {
+		_, _ = suite.DBPool.Exec(context.Background(), `DROP TABLE account_flags;`)
+	}()
+
+	if _, err := tester.GenerateSeed(suite.TestContext, suite.DBPool, "account_flags", 201, opts); err == nil {
+		suite.T().Fatal("expected generating more rows than combinations of accounts and flags to fail")
+	}
+
+	flags, err := tester.GenerateSeed(suite.TestContext, suite.DBPool, "account_flags", 200, opts)
+	if err != nil {
+		suite.T().Fatal(err)
+	}
+
+	if err := tester.SeedData(suite.TestContext, suite.DBPool, []sakerhet.PostgreSQLIntegrationTestSeed{flags}); err != nil {
+		suite.T().Fatal(err)
+	}
+
+	// overriding a column of the key leaves its combinations to the override
+	opts.Overrides["flag"] = func(r *rand.Rand, i int) any { return i%2 == 0 }
+
+	if _, err := tester.GenerateSeed(suite.TestContext, suite.DBPool, "account_flags", math.MaxInt16, opts); err == nil {
+		suite.T().Fatal("expected generating more unique smallints than the type holds to fail")
+	}
+}
+
+// High level test polling expectations on data written asynchronously
+func (suite *PostgreSQLTestSuite) TestHighLevelIntegrationTestPostgreSQLEventually() {
+	tester := suite.IntegrationTester.PostgreSQLIntegrationTester
+
+	situation := sakerhet.PostgreSQLIntegrationTestSituation{
+		Action: func(ctx context.Context, db sakerhet.PostgreSQLExecutor) error {
+			go func() {
+				time.Sleep(300 * time.Millisecond)
+
+				_, _ = db.Exec(
+					context.Background(),
+					`INSERT INTO accounts (username, email, age) VALUES ($1, $2, $3);`,
+					"myUser", "myEmail", 25,
+				)
+			}()
+
+			return nil
+		},
+		Expects: []sakerhet.PostgreSQLIntegrationTestExpectation{
+			{
+				GetQuery:       `SELECT username, age FROM accounts;`,
+				ExpectedValues: []any{[]any{"myUser", int32(25)}},
+			},
+		},
+		Eventually: &sakerhet.PostgreSQLEventuallyOptions{Interval: 50 * time.Millisecond, Backoff: 2},
+	}
+
+	if err := situation.Run(suite.TestContext, suite.DBPool); err != nil {
+		suite.T().Fatal(err)
+	}
+
+	err := tester.CheckEventually(suite.TestContext, suite.DBPool, []sakerhet.PostgreSQLIntegrationTestExpectation{
+		{
+			GetQuery:       `SELECT username, age FROM accounts;`,
+			ExpectedValues: []any{[]any{"myUser", int32(30)}},
+		},
+	}, sakerhet.PostgreSQLEventuallyOptions{Interval: 50 * time.Millisecond, Timeout: 500 * time.Millisecond})
+
+	var eventuallyErr *sakerhet.PostgreSQLEventuallyError
+	if !errors.As(err, &eventuallyErr) {
+		suite.T().Fatalf("expected a %T, got %v", eventuallyErr, err)
+	}
+
+	suite.Greater(eventuallyErr.Attempts, 1)
+	suite.Equal([]any{[]any{"myUser", int32(25)}}, eventuallyErr.Last.Failures[0].Received)
+	suite.Contains(err.Error(), "last observed rows")
+}
+
+// High level test asserting the exact row changes made by an operation
+func (suite *PostgreSQLTestSuite) TestHighLevelIntegrationTestPostgreSQLChangeRecorder() {
+	tester := suite.IntegrationTester.PostgreSQLIntegrationTester
+
+	if err := tester.SeedData(suite.TestContext, suite.DBPool, []sakerhet.PostgreSQLIntegrationTestSeed{
+		{
+			InsertQuery:  `INSERT INTO accounts (username, email, age) VALUES ($1, $2, $3);`,
+			InsertValues: [][]any{{"myUser", "myEmail", 25}},
+		},
+	}); err != nil {
+		suite.T().Fatal(err)
+	}
+
+	recorder := tester.NewChangeRecorder(suite.DBPool, "accounts", "posts")
+
+	if err := recorder.Start(suite.TestContext); err != nil {
+		suite.T().Fatal(err)
+	}
+
+	if err := sakerhet.InitPostgreSQLSchema(suite.TestContext, suite.DBPool, []string{
+		`INSERT INTO accounts (username, email, age) VALUES ('mySecondUser', 'mySecondEmail', 50);`,
+		`UPDATE accounts SET age = 26 WHERE username = 'myUser';`,
+		`INSERT INTO posts (user_id, title) SELECT user_id, 'Hej' FROM accounts WHERE username = 'myUser';`,
+	}); err != nil {
+		suite.T().Fatal(err)
+	}
+
+	if err := recorder.Stop(suite.TestContext); err != nil {
+		suite.T().Fatal(err)
+	}
+
+	// not recorded anymore
+	if _, err := suite.DBPool.Exec(suite.TestContext, `DELETE FROM posts;`); err != nil {
+		suite.T().Fatal(err)
+	}
+
+	// recorded rows keep the types of their columns
+	changes, err := recorder.Changes(suite.TestContext)
+	if err != nil {
+		suite.T().Fatal(err)
+	}
+
+	suite.Len(changes, 3)
+	suite.Equal(int32(50), changes[0].New["age"])
+	suite.Equal("mySecondUser", changes[0].New["username"])
+
+	expected := []sakerhet.PostgreSQLChange{
+		{Table: "accounts", Operation: sakerhet.PostgreSQLInsert, New: sakerhet.PostgreSQLTableRow{"username": "mySecondUser", "age": 50}},
+		{
+			Table:     "accounts",
+			Operation: sakerhet.PostgreSQLUpdate,
+			Old:       sakerhet.PostgreSQLTableRow{"username": "myUser", "age": 25},
+			New:       sakerhet.PostgreSQLTableRow{"username": "myUser", "age": 26},
+		},
+		{Table: "posts", Operation: sakerhet.PostgreSQLInsert, New: sakerhet.PostgreSQLTableRow{"title": "Hej", "user_id": sakerhet.AnyNonNull()}},
+	}
+
+	if err := recorder.AssertChanges(suite.TestContext, expected); err != nil {
+		suite.T().Fatal(err)
+	}
+
+	err = recorder.AssertChanges(suite.TestContext, expected[:2])
+	if err == nil {
+		suite.T().Fatal("expected the change assertion to fail")
+	}
+
+	suite.Contains(err.Error(), "+ INSERT on posts")
+}
+
+// High level test asserting exact changes of bigint and numeric columns
+func (suite *PostgreSQLTestSuite) TestHighLevelIntegrationTestPostgreSQLChangeRecorderExactNumbers() {
+	tester := suite.IntegrationTester.PostgreSQLIntegrationTester
+
+	if _, err := suite.DBPool.Exec(suite.TestContext, `CREATE TABLE ledger (id BIGINT NOT NULL, amount NUMERIC(12, 2) NOT NULL);`); err != nil {
+		suite.T().Fatal(err)
+	}
+
+	defer func() {
+		_, _ = suite.DBPool.Exec(context.Background(), `DROP TABLE ledger;`)
+	}()
+
+	recorder := tester.NewChangeRecorder(suite.DBPool, "ledger")
+
+	if err := recorder.Start(suite.TestContext); err != nil {
+		suite.T().Fatal(err)
+	}
+
+	if _, err := suite.DBPool.Exec(suite.TestContext, `INSERT INTO ledger VALUES (9007199254740993, 1.50);`); err != nil {
+		suite.T().Fatal(err)
+	}
+
+	if err := recorder.Stop(suite.TestContext); err != nil {
+		suite.T().Fatal(err)
+	}
+
+	if err := recorder.AssertChanges(suite.TestContext, []sakerhet.PostgreSQLChange{
+		{Table: "ledger", Operation: sakerhet.PostgreSQLInsert, New: sakerhet.PostgreSQLTableRow{"id": int64(9007199254740993), "amount": "1.50"}},
+	}); err != nil {
+		suite.T().Fatal(err)
+	}
+
+	// equal once converted to float64
+	err := recorder.AssertChanges(suite.TestContext, []sakerhet.PostgreSQLChange{
+		{Table: "ledger", Operation: sakerhet.PostgreSQLInsert, New: sakerhet.PostgreSQLTableRow{"id": int64(9007199254740992), "amount": "1.50"}},
+	})
+	if err == nil {
+		suite.T().Fatal("expected the change assertion to fail")
+	}
+
+	suite.Contains(err.Error(), "id:9007199254740993")
+
+	suite.Error(recorder.AssertChanges(suite.TestContext, []sakerhet.PostgreSQLChange{
+		{Table: "ledger", Operation: sakerhet.PostgreSQLInsert, New: sakerhet.PostgreSQLTableRow{"id": int64(9007199254740993), "amount": 1.5000000001}},
+	}))
+}
+
+// High level test counting the queries issued by code under test
+func (suite *PostgreSQLTestSuite) TestHighLevelIntegrationTestPostgreSQLQueryRecorder() {
+	tester := suite.IntegrationTester.PostgreSQLIntegrationTester
+
+	if err := tester.SeedData(suite.TestContext, suite.DBPool, []sakerhet.PostgreSQLIntegrationTestSeed{
+		{
+			InsertQuery:  `INSERT INTO accounts (username, email, age) VALUES ($1, $2, $3);`,
+			InsertValues: [][]any{{"myUser", "myEmail", 25}, {"mySecondUser", "mySecondEmail", 50}, {"myThirdUser", "myThirdEmail", 75}},
+		},
+	}); err != nil {
+		suite.T().Fatal(err)
+	}
+
+	tester.QueryRecorder.Reset()
+
+	// code under test loading the posts of every account one by one
+	ids, err := sakerhet.FetchInto[int32](suite.TestContext, suite.DBPool, `SELECT user_id FROM accounts;`)
+	if err != nil {
+		suite.T().Fatal(err)
+	}
+
+	for _, id := range ids {
+		if _, err := sakerhet.FetchInto[string](suite.TestContext, suite.DBPool, `SELECT title FROM posts WHERE user_id = $1;`, id); err != nil {
+			suite.T().Fatal(err)
+		}
+	}
+
+	recorder := tester.QueryRecorder
+
+	suite.NoError(recorder.AssertAtMost(4))
+	suite.NoError(recorder.AssertNoQueryMatching(`(?i)^\s*(UPDATE|DELETE)`))
+	suite.ErrorContains(recorder.AssertNoRepeatedQueries(1), "3x SELECT title FROM posts WHERE user_id = $1;")
+}
+
+// High level test on code that has to survive network faults between it and PostgreSQL
+func (suite *PostgreSQLTestSuite) TestHighLevelIntegrationTestPostgreSQLFaultProxy() {
+	tester := suite.IntegrationTester.PostgreSQLIntegrationTester
+
+	proxy, err := tester.NewFaultProxy(nil)
+	if err != nil {
+		suite.T().Fatal(err)
+	}
+
+	defer proxy.Close()
+
+	// code under test connecting through the proxy
+	pool, err := pgxpool.New(suite.TestContext, proxy.ConnectionURL)
+	if err != nil {
+		suite.T().Fatal(err)
+	}
+
+	defer pool.Close()
+
+	if _, err := pool.Exec(suite.TestContext, `SELECT 1;`); err != nil {
+		suite.T().Fatal(err)
+	}
+
+	proxy.SetLatency(200 * time.Millisecond)
+
+	start := time.Now()
+
+	if _, err := pool.Exec(suite.TestContext, `SELECT 1;`); err != nil {
+		suite.T().Fatal(err)
+	}
+
+	suite.GreaterOrEqual(time.Since(start), 400*time.Millisecond)
+
+	proxy.Reset()
+
+	// dropped in the middle of a query
+	time.AfterFunc(200*time.Millisecond, proxy.DropConnections)
+
+	_, err = pool.Exec(suite.TestContext, `SELECT pg_sleep(5);`)
+	suite.Error(err)
+
+	proxy.RefuseConnections(true)
+
+	_, err = pool.Exec(suite.TestContext, `SELECT 1;`)
+	suite.Error(err)
+
+	proxy.RefuseConnections(false)
+
+	// the pool recovers once the faults are gone
+	if _, err := pool.Exec(suite.TestContext, `SELECT 1;`); err != nil {
+		suite.T().Fatal(err)
+	}
+}
+
+// High level test on the locking of SELECT ... FOR UPDATE between two sessions
+func (suite *PostgreSQLTestSuite) TestHighLevelIntegrationTestPostgreSQLScenarioForUpdate() {
+	tester := suite.IntegrationTester.PostgreSQLIntegrationTester
+
+	if err := tester.SeedData(suite.TestContext, suite.DBPool, []sakerhet.PostgreSQLIntegrationTestSeed{
+		{
+			InsertQuery:  `INSERT INTO accounts (username, email, age) VALUES ($1, $2, $3);`,
+			InsertValues: [][]any{{"myUser", "myEmail", 25}},
+		},
+	}); err != nil {
+		suite.T().Fatal(err)
+	}
+
+	result, err := tester.RunScenario(suite.TestContext, suite.DBPool, sakerhet.PostgreSQLScenario{
+		Steps: []sakerhet.PostgreSQLScenarioStep{
+			{Session: "A", SQL: `BEGIN;`},
+			{Session: "A", SQL: `SELECT age FROM accounts WHERE username = 'myUser' FOR UPDATE;`},
+			{Session: "B", SQL: `BEGIN;`},
+			{Session: "B", SQL: `UPDATE accounts SET age = age + 1 WHERE username = 'myUser';`, Blocks: true},
+			{Session: "A", SQL: `UPDATE accounts SET age = 30 WHERE username = 'myUser';`},
+			{Session: "A", SQL: `COMMIT;`},
+			{Session: "B", SQL: `COMMIT;`},
+		},
+	})
+	if err != nil {
+		suite.T().Fatal(err)
+	}
+
+	suite.NoError(result.AssertSessionSucceeded("A"))
+	suite.NoError(result.AssertSessionSucceeded("B"))
+
+	// the update of B waited for A and saw its change
+	if err := tester.AssertTableRows(suite.TestContext, suite.DBPool, "accounts", []sakerhet.PostgreSQLTableRow{
+		{"username": "myUser", "age": 31},
+	}, sakerhet.PostgreSQLTableAssertOptions{}); err != nil {
+		suite.T().Fatal(err)
+	}
+}
+
+// High level test on the serialization failure of concurrent repeatable read transactions
+func (suite *PostgreSQLTestSuite) TestHighLevelIntegrationTestPostgreSQLScenarioSerializationFailure() {
+	tester := suite.IntegrationTester.PostgreSQLIntegrationTester
+
+	if err := tester.SeedData(suite.TestContext, suite.DBPool, []sakerhet.PostgreSQLIntegrationTestSeed{
+		{
+			InsertQuery:  `INSERT INTO accounts (username, email, age) VALUES ($1, $2, $3);`,
+			InsertValues: [][]any{{"myUser", "myEmail", 25}},
+		},
+	}); err != nil {
+		suite.T().Fatal(err)
+	}
+
+	var age int
+
+	result, err := tester.RunScenario(suite.TestContext, suite.DBPool, sakerhet.PostgreSQLScenario{
+		Steps: []sakerhet.PostgreSQLScenarioStep{
+			{Session: "A", SQL: `BEGIN ISOLATION LEVEL REPEATABLE READ;`},
+			{Session: "A", Action: func(ctx context.Context, conn sakerhet.PostgreSQLExecutor) error {
+				return conn.QueryRow(ctx, `SELECT age FROM accounts WHERE username = 'myUser';`).Scan(&age)
+			}},
+			{Session: "B", SQL: `BEGIN;`},
+			{Session: "B", SQL: `UPDATE accounts SET age = 26 WHERE username = 'myUser';`},
+			{Session: "A", SQL: `UPDATE accounts SET age = $1 WHERE username = 'myUser';`, Args: []any{27}, Blocks: true},
+			{Session: "B", SQL: `COMMIT;`},
+			{Session: "A", SQL: `ROLLBACK;`},
+		},
+	})
+	if err != nil {
+		suite.T().Fatal(err)
+	}
+
+	suite.Equal(25, age)
+	suite.NoError(result.AssertSessionSucceeded("B"))
+	suite.NoError(result.AssertSessionFailed("A", sakerhet.IsSerializationFailure))
+}
+
+// High level test on the deadlock of two sessions updating the same rows in opposite orders
+func (suite *PostgreSQLTestSuite) TestHighLevelIntegrationTestPostgreSQLScenarioDeadlock() {
+	tester := suite.IntegrationTester.PostgreSQLIntegrationTester
+
+	if err := tester.SeedData(suite.TestContext, suite.DBPool, []sakerhet.PostgreSQLIntegrationTestSeed{
+		{
+			InsertQuery:  `INSERT INTO accounts (username, email, age) VALUES ($1, $2, $3);`,
+			InsertValues: [][]any{{"myUser", "myEmail", 25}, {"mySecondUser", "mySecondEmail", 50}},
+		},
+	}); err != nil {
+		suite.T().Fatal(err)
+	}
+
+	result, err := tester.RunScenario(suite.TestContext, suite.DBPool, sakerhet.PostgreSQLScenario{
+		Steps: []sakerhet.PostgreSQLScenarioStep{
+			// B detects the deadlock first, making it the one aborted
+			{Session: "A", SQL: `BEGIN;`},
+			{Session: "A", SQL: `SET LOCAL deadlock_timeout = '10s';`},
+			{Session: "A", SQL: `UPDATE accounts SET age = 26 WHERE username = 'myUser';`},
+			{Session: "B", SQL: `BEGIN;`},
+			{Session: "B", SQL: `UPDATE accounts SET age = 51 WHERE username = 'mySecondUser';`},
+			{Session: "A", SQL: `UPDATE accounts SET age = 52 WHERE username = 'mySecondUser';`, Blocks: true},
+			{Session: "B", SQL: `UPDATE accounts SET age = 27 WHERE username = 'myUser';`},
+			{Session: "B", SQL: `ROLLBACK;`},
+			{Session: "A", SQL: `COMMIT;`},
+		},
+	})
+	if err != nil {
+		suite.T().Fatal(err)
+	}
+
+	suite.NoError(result.AssertSessionSucceeded("A"))
+	suite.NoError(result.AssertSessionFailed("B", sakerhet.IsDeadlock))
+}
+
+// Low level test with full control on testing code that uses PostgreSQL
+func TestLowLevelIntegrationTestPostgreSQL(t *testing.T) {
+	sakerhet.SkipIntegrationTestsWhenUnitTesting(t)
+
+	// given
+	password := fmt.Sprintf("password-%s", uuid.NewString())
+	user := fmt.Sprintf("user-%s", uuid.NewString())
+	db := fmt.Sprintf("db-%s", uuid.NewString())
+
+	postgreSQLC, err := abstractedcontainers.SetupPostgreSQL(context.Background(), user, password, db)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), sakerhet.GetIntegrationTestTimeout())
+	defer cancel()
+
+	// clean up the container after the test is complete
+	defer func() {
+		_ = postgreSQLC.Terminate(context.Background())
+	}()
+
+	dbpool, err := pgxpool.New(ctx, postgreSQLC.ConnectionURL)
+	if err != nil {
+		t.Fatal(fmt.Errorf("Unable to create connection pool: %v\n", err))
+	}
+
+	defer dbpool.Close()
+
+	initialSchema := []string{
+		`
+		CREATE TABLE accounts (
+				user_id serial PRIMARY KEY,
+				username VARCHAR ( 50 ) UNIQUE NOT NULL,
+				email VARCHAR ( 255 ) UNIQUE NOT NULL,
+				age INTEGER NOT NULL,
+	      created_on INTEGER NOT NULL DEFAULT extract(epoch from now())
+    );
+		`,
+	}
+
+	if err := sakerhet.InitPostgreSQLSchema(ctx, dbpool, initialSchema); err != nil {
+		t.Fatal(err)
+	}
+
+	insertQuery := `INSERT INTO accounts (username, email, age, created_on) VALUES ($1, $2, $3, $4);`
+	seedData := [][]any{
+		{"myUser", "myEmail", 25, 1234567},
+	}
+
+	// when
+	if err := sakerhet.SeedPostgreSQLData(ctx, dbpool, insertQuery, seedData); err != nil {
+		t.Fatal(err)
+	}
+
+	// then
+	type account struct {
+		userId    int
+		username  string
+		email     string
+		age       int
+		createdOn int
+	}
+
+	getQuery := `SELECT user_id, username, email, age, created_on FROM accounts;`
+
+	rows, err := dbpool.Query(ctx, getQuery)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer rows.Close()
+
+	var result []account
+
+	for rows.Next() {
+		var acc account
+
+		if err := rows.Scan(&acc.userId, &acc.username, &acc.email, &acc.age, &acc.createdOn); err != nil {
+			t.Fatal(err)
+		}
+
+		result = append(result, acc)
+	}
+
+	expected := []account{
+		{userId: 1, username: "myUser", email: "myEmail", age: 25, createdOn: 1234567},
+	}
+
+	if diff := sakerhet.UnorderedDiff(expected, result); !diff.Equal() {
+		t.Fatal(fmt.Errorf("received data is different than expected:\n%s", diff))
+	}
+}
+
+// Test of capturing a seeded database once and restoring it between tests
+func TestIntegrationTestPostgreSQLSnapshot(t *testing.T) {
+	sakerhet.SkipIntegrationTestsWhenUnitTesting(t)
+
+	ctx, cancel := context.WithTimeout(context.Background(), sakerhet.GetIntegrationTestTimeout())
+	defer cancel()
+
+	tester := sakerhet.NewPostgreSQLIntegrationTester(&sakerhet.PostgreSQLIntegrationTestParams{})
+
+	postgreSQLC, err := tester.ContainerStart(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer func() {
+		_ = postgreSQLC.Terminate(context.Background())
+	}()
+
+	conn, err := pgx.Connect(ctx, postgreSQLC.ConnectionURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := sakerhet.InitPostgreSQLSchema(ctx, conn, []string{
+		`CREATE TABLE books (title TEXT NOT NULL)`,
+		`INSERT INTO books (title) VALUES ('Pippi Långstrump')`,
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	// snapshots need every connection to the database closed
+	if err := postgreSQLC.Snapshot(ctx, "seeded"); !errors.Is(err, abstractedcontainers.ErrDatabaseInUse) {
+		t.Fatalf("expected %v, got %v", abstractedcontainers.ErrDatabaseInUse, err)
+	}
+
+	_ = conn.Close(ctx)
+
+	if err := postgreSQLC.Snapshot(ctx, "seeded"); err != nil {
+		t.Fatal(err)
+	}
+
+	conn, err = pgx.Connect(ctx, postgreSQLC.ConnectionURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := conn.Exec(ctx, `DELETE FROM books;`); err != nil {
+		t.Fatal(err)
+	}
+
+	_ = conn.Close(ctx)
+
+	if err := postgreSQLC.Restore(ctx, "seeded"); err != nil {
+		t.Fatal(err)
+	}
+
+	conn, err = pgx.Connect(ctx, postgreSQLC.ConnectionURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer conn.Close(context.Background())
+
+	titles, err := sakerhet.FetchInto[string](ctx, conn, `SELECT title FROM books;`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if diff := sakerhet.UnorderedDiff([]string{"Pippi Långstrump"}, titles); !diff.Equal() {
+		t.Fatal(diff)
+	}
+}
+
+// Test of snapshots taken through the tester, which reopens its own connections
+func TestIntegrationTestPostgreSQLTesterSnapshot(t *testing.T) {
+	sakerhet.SkipIntegrationTestsWhenUnitTesting(t)
+
+	ctx, cancel := context.WithTimeout(context.Background(), sakerhet.GetIntegrationTestTimeout())
+	defer cancel()
+
+	tester := sakerhet.NewPostgreSQLIntegrationTester(&sakerhet.PostgreSQLIntegrationTestParams{})
+
+	if err := tester.Start(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	defer func() {
+		_ = tester.Terminate(context.Background())
+	}()
+
+	if err := sakerhet.InitPostgreSQLSchema(ctx, tester.Pool, []string{
+		`CREATE TABLE books (title TEXT NOT NULL)`,
+		`INSERT INTO books (title) VALUES ('Pippi Långstrump')`,
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := tester.Snapshot(ctx, "seeded"); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := tester.SQLDB.ExecContext(ctx, `DELETE FROM books;`); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := tester.Restore(ctx, "seeded"); err != nil {
+		t.Fatal(err)
+	}
+
+	titles, err := sakerhet.FetchInto[string](ctx, tester.Pool, `SELECT title FROM books;`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if diff := sakerhet.UnorderedDiff([]string{"Pippi Långstrump"}, titles); !diff.Equal() {
+		t.Fatal(diff)
+	}
+}
+
+// Test of a container with a custom image, server settings, init scripts and extensions
+func TestIntegrationTestPostgreSQLContainerOptions(t *testing.T) {
+	sakerhet.SkipIntegrationTestsWhenUnitTesting(t)
+
+	ctx, cancel := context.WithTimeout(context.Background(), sakerhet.GetIntegrationTestTimeout())
+	defer cancel()
+
+	tester := sakerhet.NewPostgreSQLIntegrationTester(&sakerhet.PostgreSQLIntegrationTestParams{
+		Image:        "postgres",
+		Tag:          "15",
+		Settings:     map[string]string{"fsync": "off", "max_connections": "42"},
+		InitScripts:  []string{"testdata/init/create_greetings.sql"},
+		Extensions:   []string{"pgcrypto"},
+		TmpfsDataDir: true,
+	})
+
+	postgreSQLC, err := tester.ContainerStart(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer func() {
+		_ = postgreSQLC.Terminate(context.Background())
+	}()
+
+	conn, err := pgx.Connect(ctx, postgreSQLC.ConnectionURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer conn.Close(ctx)
+
+	var version, maxConnections, message string
+	var hasPgcrypto bool
+
+	if err := conn.QueryRow(ctx, `
+		SELECT
+			current_setting('server_version_num'),
+			current_setting('max_connections'),
+			(SELECT message FROM greetings),
+			EXISTS (SELECT 1 FROM pg_extension WHERE extname = 'pgcrypto');
+	`).Scan(&version, &maxConnections, &message, &hasPgcrypto); err != nil {
+		t.Fatal(err)
+	}
+
+	assert.True(t, strings.HasPrefix(version, "15"))
+	assert.Equal(t, "42", maxConnections)
+	assert.Equal(t, "hej", message)
+	assert.True(t, hasPgcrypto)
+}
+
+// Test running the same checks against several PostgreSQL versions
+func TestIntegrationTestPostgreSQLMatrix(t *testing.T) {
+	sakerhet.SkipIntegrationTestsWhenUnitTesting(t)
+
+	defer func() {
+		if err := sakerhet.TerminatePostgreSQLMatrixContainers(context.Background()); err != nil {
+			t.Error(err)
+		}
+	}()
+
+	versions := map[string]string{}
+
+	sakerhet.RunPostgreSQLMatrix(
+		t,
+		[]string{"14.5", "16"},
+		sakerhet.PostgreSQLIntegrationTestParams{TmpfsDataDir: true},
+		func(t *testing.T, tester *sakerhet.PostgreSQLIntegrationTester, container *abstractedcontainers.PostgreSQLContainer) {
+			ctx, cancel := context.WithTimeout(context.Background(), sakerhet.GetIntegrationTestTimeout())
+			defer cancel()
+
+			conn, err := pgx.Connect(ctx, container.ConnectionURL)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			defer conn.Close(ctx)
+
+			var version string
+
+			if err := conn.QueryRow(ctx, `SELECT current_setting('server_version_num');`).Scan(&version); err != nil {
+				t.Fatal(err)
+			}
+
+			versions[t.Name()] = version[:2]
+		},
+	)
+
+	assert.Equal(t, map[string]string{
+		"TestIntegrationTestPostgreSQLMatrix/pg14": "14",
+		"TestIntegrationTestPostgreSQLMatrix/pg16": "16",
+	}, versions)
 }
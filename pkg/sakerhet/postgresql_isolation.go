@@ -0,0 +1,30 @@
+package sakerhet
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// Begin a transaction that is rolled back when the test finishes, so nothing the test writes through it outlives the test.
+// Given a pool each test gets its own connection, allowing parallel tests on one container,
+// given a pgx.Tx a savepoint is used instead, to isolate subtests from their parent test.
+// Pass the returned transaction to the code under test and to the helpers accepting a PostgreSQLExecutor.
+func (p *PostgreSQLIntegrationTester) IsolatedTx(ctx context.Context, t testing.TB, db PostgreSQLTxStarter) pgx.Tx {
+	t.Helper()
+
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		t.Fatalf("beginning isolated transaction: %v", err)
+	}
+
+	t.Cleanup(func() {
+		if err := tx.Rollback(context.Background()); err != nil && !errors.Is(err, pgx.ErrTxClosed) {
+			t.Errorf("rolling back isolated transaction: %v", err)
+		}
+	})
+
+	return tx
+}
@@ -0,0 +1,125 @@
+package sakerhet
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+
+	abstractedcontainers "github.com/averageflow/sakerhet/pkg/abstracted_containers"
+)
+
+type postgreSQLMatrixContainer struct {
+	once      sync.Once
+	tester    *PostgreSQLIntegrationTester
+	container *abstractedcontainers.PostgreSQLContainer
+	err       error
+}
+
+var (
+	postgreSQLMatrixMu         sync.Mutex
+	postgreSQLMatrixContainers = make(map[string]*postgreSQLMatrixContainer)
+)
+
+// Run fn as one subtest per PostgreSQL version (an image tag such as 14.5 or 16), named like pg14 and pg16.
+// The Tag of params is replaced by each version, the rest configures the containers.
+// A container is started once per version and configuration and reused by every matrix of the test binary,
+// so tests should isolate their data, e.g. with NewDatabaseFromTemplate, IsolatedTx or ResetDatabase.
+// Containers are removed when the test binary exits, or earlier by TerminatePostgreSQLMatrixContainers.
+func RunPostgreSQLMatrix(
+	t *testing.T,
+	versions []string,
+	params PostgreSQLIntegrationTestParams,
+	fn func(t *testing.T, tester *PostgreSQLIntegrationTester, container *abstractedcontainers.PostgreSQLContainer),
+) {
+	t.Helper()
+
+	names := postgreSQLMatrixNames(versions)
+
+	for i, version := range versions {
+		versionParams := params
+		versionParams.Tag = version
+
+		t.Run(names[i], func(t *testing.T) {
+			entry := postgreSQLMatrixContainerFor(versionParams)
+
+			entry.once.Do(func() {
+				ctx, cancel := context.WithTimeout(context.Background(), GetIntegrationTestTimeout())
+				defer cancel()
+
+				entry.tester = NewPostgreSQLIntegrationTester(&versionParams)
+				entry.container, entry.err = entry.tester.ContainerStart(ctx)
+			})
+
+			if entry.err != nil {
+				t.Fatalf("starting PostgreSQL %s: %v", version, entry.err)
+			}
+
+			fn(t, entry.tester, entry.container)
+		})
+	}
+}
+
+// Terminate the containers started by RunPostgreSQLMatrix, e.g. at the end of TestMain
+func TerminatePostgreSQLMatrixContainers(ctx context.Context) error {
+	postgreSQLMatrixMu.Lock()
+	defer postgreSQLMatrixMu.Unlock()
+
+	var failures []string
+
+	for key, entry := range postgreSQLMatrixContainers {
+		if entry.container != nil {
+			if err := entry.container.Terminate(ctx); err != nil {
+				failures = append(failures, err.Error())
+			}
+		}
+
+		delete(postgreSQLMatrixContainers, key)
+	}
+
+	if len(failures) > 0 {
+		return fmt.Errorf("terminating PostgreSQL containers: %s", strings.Join(failures, "; "))
+	}
+
+	return nil
+}
+
+func postgreSQLMatrixContainerFor(params PostgreSQLIntegrationTestParams) *postgreSQLMatrixContainer {
+	// fmt prints maps sorted by key, so equal configurations give equal keys
+	key := fmt.Sprintf("%#v", params)
+
+	postgreSQLMatrixMu.Lock()
+	defer postgreSQLMatrixMu.Unlock()
+
+	entry, ok := postgreSQLMatrixContainers[key]
+	if !ok {
+		entry = &postgreSQLMatrixContainer{}
+		postgreSQLMatrixContainers[key] = entry
+	}
+
+	return entry
+}
+
+// Subtests are named after the major version, or the whole version when two share a major one
+func postgreSQLMatrixNames(versions []string) []string {
+	majors := make(map[string]int, len(versions))
+
+	for _, v := range versions {
+		majors[strings.SplitN(v, ".", 2)[0]]++
+	}
+
+	names := make([]string, len(versions))
+
+	for i, v := range versions {
+		major := strings.SplitN(v, ".", 2)[0]
+
+		if majors[major] > 1 {
+			names[i] = "pg" + v
+		} else {
+			names[i] = "pg" + major
+		}
+	}
+
+	return names
+}
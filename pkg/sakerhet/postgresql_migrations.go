@@ -0,0 +1,388 @@
+package sakerhet
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Table recording the versions of the applied migrations, keyed by the directory of their set
+// so that several migration sets can be applied to the same database
+const PostgreSQLMigrationsTable = "sakerhet_schema_migrations"
+
+// Migration files are named <version>_<name>.up.sql and <version>_<name>.down.sql,
+// a <version>_<name>.sql file is an up migration without its down counterpart
+var postgreSQLMigrationFileName = regexp.MustCompile(`^(\d+)_(.+?)(\.up|\.down)?\.sql$`)
+
+type PostgreSQLMigration struct {
+	Version  int64
+	Name     string
+	UpFile   string
+	DownFile string
+}
+
+// Failure of a single statement of a migration file
+type PostgreSQLMigrationError struct {
+	Version int64
+	File    string
+	// 1-based position of the failing statement in the file
+	StatementIndex int
+	Statement      string
+	Err            error
+}
+
+func (e *PostgreSQLMigrationError) Error() string {
+	return fmt.Sprintf(
+		"migration %s failed at statement #%d:\n%s\n%v",
+		e.File,
+		e.StatementIndex,
+		strings.TrimSpace(e.Statement),
+		e.Err,
+	)
+}
+
+func (e *PostgreSQLMigrationError) Unwrap() error {
+	return e.Err
+}
+
+// Apply all the pending migrations found in dir of fsys, use os.DirFS for a directory on disk or an embed.FS.
+// The applied versions are tracked per dir, so each migration set needs a distinct dir.
+func (p *PostgreSQLIntegrationTester) MigrateUp(ctx context.Context, db PostgreSQLExecutor, fsys fs.FS, dir string) error {
+	_, err := MigratePostgreSQLUp(ctx, db, fsys, dir)
+	return err
+}
+
+// Revert the given amount of most recently applied migrations found in dir of fsys
+func (p *PostgreSQLIntegrationTester) MigrateDown(ctx context.Context, db PostgreSQLExecutor, fsys fs.FS, dir string, steps int) error {
+	_, err := MigratePostgreSQLDown(ctx, db, fsys, dir, steps)
+	return err
+}
+
+// Read the migrations in dir of fsys, sorted by version
+func ReadPostgreSQLMigrations(fsys fs.FS, dir string) ([]PostgreSQLMigration, error) {
+	entries, err := fs.ReadDir(fsys, dir)
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := make(map[int64]*PostgreSQLMigration)
+
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+
+		match := postgreSQLMigrationFileName.FindStringSubmatch(entry.Name())
+		if match == nil {
+			continue
+		}
+
+		version, err := strconv.ParseInt(match[1], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("migration %s: %w", entry.Name(), err)
+		}
+
+		migration, exists := byVersion[version]
+		if !exists {
+			migration = &PostgreSQLMigration{Version: version, Name: match[2]}
+			byVersion[version] = migration
+		}
+
+		if migration.Name != match[2] {
+			return nil, fmt.Errorf("migration version %d used by both %s and %s", version, migration.Name, match[2])
+		}
+
+		file := path.Join(dir, entry.Name())
+
+		if match[3] == ".down" {
+			migration.DownFile = file
+			continue
+		}
+
+		if migration.UpFile != "" {
+			return nil, fmt.Errorf("migration version %d has more than one up file", version)
+		}
+
+		migration.UpFile = file
+	}
+
+	migrations := make([]PostgreSQLMigration, 0, len(byVersion))
+
+	for _, v := range byVersion {
+		if v.UpFile == "" {
+			return nil, fmt.Errorf("migration version %d has no up file", v.Version)
+		}
+
+		migrations = append(migrations, *v)
+	}
+
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].Version < migrations[j].Version
+	})
+
+	return migrations, nil
+}
+
+// Apply the pending migrations in order, each one in its own transaction, returning the applied versions
+func MigratePostgreSQLUp(ctx context.Context, db PostgreSQLExecutor, fsys fs.FS, dir string) ([]int64, error) {
+	migrations, err := ReadPostgreSQLMigrations(fsys, dir)
+	if err != nil {
+		return nil, err
+	}
+
+	applied, err := appliedPostgreSQLMigrations(ctx, db, dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var versions []int64
+
+	for _, v := range migrations {
+		if applied[v.Version] {
+			continue
+		}
+
+		if err := runPostgreSQLMigrationFile(ctx, db, fsys, v.Version, v.UpFile, func(tx PostgreSQLExecutor) error {
+			_, err := tx.Exec(
+				ctx,
+				fmt.Sprintf(`INSERT INTO %s (directory, version, name) VALUES ($1, $2, $3);`, PostgreSQLMigrationsTable),
+				dir,
+				v.Version,
+				v.Name,
+			)
+			return err
+		}); err != nil {
+			return versions, err
+		}
+
+		versions = append(versions, v.Version)
+	}
+
+	return versions, nil
+}
+
+// Revert the latest applied migrations in reverse order, returning the reverted versions
+func MigratePostgreSQLDown(ctx context.Context, db PostgreSQLExecutor, fsys fs.FS, dir string, steps int) ([]int64, error) {
+	migrations, err := ReadPostgreSQLMigrations(fsys, dir)
+	if err != nil {
+		return nil, err
+	}
+
+	applied, err := appliedPostgreSQLMigrations(ctx, db, dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var versions []int64
+
+	for i := len(migrations) - 1; i >= 0 && len(versions) < steps; i-- {
+		v := migrations[i]
+
+		if !applied[v.Version] {
+			continue
+		}
+
+		if v.DownFile == "" {
+			return versions, fmt.Errorf("migration version %d has no down file", v.Version)
+		}
+
+		if err := runPostgreSQLMigrationFile(ctx, db, fsys, v.Version, v.DownFile, func(tx PostgreSQLExecutor) error {
+			_, err := tx.Exec(
+				ctx,
+				fmt.Sprintf(`DELETE FROM %s WHERE directory = $1 AND version = $2;`, PostgreSQLMigrationsTable),
+				dir,
+				v.Version,
+			)
+			return err
+		}); err != nil {
+			return versions, err
+		}
+
+		versions = append(versions, v.Version)
+	}
+
+	return versions, nil
+}
+
+func appliedPostgreSQLMigrations(ctx context.Context, db PostgreSQLExecutor, dir string) (map[int64]bool, error) {
+	if _, err := db.Exec(ctx, fmt.Sprintf(`
+		CREATE TABLE IF NOT EXISTS %s (
+			directory TEXT NOT NULL,
+			version BIGINT NOT NULL,
+			name TEXT NOT NULL,
+			applied_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+			PRIMARY KEY (directory, version)
+		);
+	`, PostgreSQLMigrationsTable)); err != nil {
+		return nil, err
+	}
+
+	rows, err := db.Query(ctx, fmt.Sprintf(`SELECT version FROM %s WHERE directory = $1;`, PostgreSQLMigrationsTable), dir)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	applied := make(map[int64]bool)
+
+	for rows.Next() {
+		var version int64
+
+		if err := rows.Scan(&version); err != nil {
+			return nil, err
+		}
+
+		applied[version] = true
+	}
+
+	return applied, rows.Err()
+}
+
+// Execute the statements of a migration file one by one, then record it, all in one transaction
+func runPostgreSQLMigrationFile(ctx context.Context, db PostgreSQLExecutor, fsys fs.FS, version int64, file string, record func(tx PostgreSQLExecutor) error) error {
+	raw, err := fs.ReadFile(fsys, file)
+	if err != nil {
+		return err
+	}
+
+	return inPostgreSQLTx(ctx, db, func(tx PostgreSQLExecutor) error {
+		for i, statement := range SplitPostgreSQLStatements(string(raw)) {
+			if _, err := tx.Exec(ctx, statement); err != nil {
+				return &PostgreSQLMigrationError{
+					Version:        version,
+					File:           file,
+					StatementIndex: i + 1,
+					Statement:      statement,
+					Err:            err,
+				}
+			}
+		}
+
+		return record(tx)
+	})
+}
+
+// Split a SQL script on the semicolons ending its statements,
+// ignoring the ones inside quotes, dollar-quoted bodies and comments
+func SplitPostgreSQLStatements(script string) []string {
+	var statements []string
+
+	start := 0
+	hasContent := false
+
+	flush := func(end int) {
+		if hasContent {
+			statements = append(statements, strings.TrimSpace(script[start:end]))
+		}
+
+		start = end + 1
+		hasContent = false
+	}
+
+	for i := 0; i < len(script); i++ {
+		c := script[i]
+
+		switch {
+		case c == ';':
+			flush(i)
+			continue
+		case c == '-' && strings.HasPrefix(script[i:], "--"):
+			if end := strings.IndexByte(script[i:], '\n'); end != -1 {
+				i += end
+			} else {
+				i = len(script)
+			}
+
+			continue
+		case c == '/' && strings.HasPrefix(script[i:], "/*"):
+			i = skipBlockComment(script, i)
+			continue
+		case c == '\'' || c == '"':
+			escapes := c == '\'' && isEscapeStringPrefix(script, i)
+			i = skipQuoted(script, i, c, escapes)
+		case c == '$':
+			if tag := dollarQuoteTag(script[i:]); tag != "" {
+				if end := strings.Index(script[i+len(tag):], tag); end != -1 {
+					i += len(tag) + end + len(tag) - 1
+				} else {
+					i = len(script)
+				}
+			}
+		}
+
+		if c != ' ' && c != '\t' && c != '\n' && c != '\r' {
+			hasContent = true
+		}
+	}
+
+	flush(len(script))
+
+	return statements
+}
+
+// An escape string starts with a standalone E as in E'\n', not with the last letter of a word as in LIKE'\'
+func isEscapeStringPrefix(script string, quote int) bool {
+	if quote == 0 || (script[quote-1] != 'E' && script[quote-1] != 'e') {
+		return false
+	}
+
+	return quote == 1 || !isIdentifierByte(script[quote-2])
+}
+
+func isIdentifierByte(c byte) bool {
+	return c == '_' || c == '$' || c >= 0x80 ||
+		(c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9')
+}
+
+// Index of the end of a possibly nested block comment starting at i
+func skipBlockComment(script string, i int) int {
+	depth := 0
+
+	for ; i < len(script); i++ {
+		switch {
+		case strings.HasPrefix(script[i:], "/*"):
+			depth++
+			i++
+		case strings.HasPrefix(script[i:], "*/"):
+			depth--
+			i++
+
+			if depth == 0 {
+				return i
+			}
+		}
+	}
+
+	return len(script)
+}
+
+// Index of the closing quote of a quoted string or identifier starting at i
+func skipQuoted(script string, i int, quote byte, escapes bool) int {
+	for i++; i < len(script); i++ {
+		switch {
+		case escapes && script[i] == '\\':
+			i++
+		case script[i] == quote:
+			if i+1 < len(script) && script[i+1] == quote {
+				i++
+				continue
+			}
+
+			return i
+		}
+	}
+
+	return len(script)
+}
+
+var postgreSQLDollarQuoteTag = regexp.MustCompile(`^\$([A-Za-z_][A-Za-z_0-9]*)?\$`)
+
+func dollarQuoteTag(s string) string {
+	return postgreSQLDollarQuoteTag.FindString(s)
+}
@@ -0,0 +1,74 @@
+package sakerhet_test
+
+import (
+	"testing"
+	"testing/fstest"
+
+	"github.com/averageflow/sakerhet/pkg/sakerhet"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/suite"
+)
+
+type PostgreSQLMigrationsTestSuite struct {
+	suite.Suite
+}
+
+func TestPostgreSQLMigrationsTestSuite(t *testing.T) {
+	sakerhet.SkipUnitTestsWhenIntegrationTesting(t)
+	t.Parallel()
+	suite.Run(t, new(PostgreSQLMigrationsTestSuite))
+}
+
+func (suite *PostgreSQLMigrationsTestSuite) TestSplitPostgreSQLStatements() {
+	assert.Equal(suite.T(), []string{
+		"CREATE TABLE a (b TEXT DEFAULT ';')",
+		"-- comment; with a semicolon\n\t\tINSERT INTO a VALUES ('it''s; fine')",
+		"CREATE FUNCTION f() RETURNS int AS $body$ SELECT 1; $body$ LANGUAGE sql",
+		`SELECT E'\'; still a string', "odd;name" FROM a /* block; /* nested; */ comment */`,
+		"SELECT $1::int\n\t\t-- trailing comment",
+	}, sakerhet.SplitPostgreSQLStatements(`
+		CREATE TABLE a (b TEXT DEFAULT ';');
+		-- comment; with a semicolon
+		INSERT INTO a VALUES ('it''s; fine');;
+		CREATE FUNCTION f() RETURNS int AS $body$ SELECT 1; $body$ LANGUAGE sql;
+		SELECT E'\'; still a string', "odd;name" FROM a /* block; /* nested; */ comment */;
+		SELECT $1::int
+		-- trailing comment
+	`))
+}
+
+func (suite *PostgreSQLMigrationsTestSuite) TestSplitPostgreSQLStatementsEscapeStrings() {
+	assert.Equal(suite.T(), []string{
+		`SELECT 'a\' FROM t WHERE name LIKE'b\'`,
+		`SELECT e'it\'s'`,
+	}, sakerhet.SplitPostgreSQLStatements(`SELECT 'a\' FROM t WHERE name LIKE'b\'; SELECT e'it\'s';`))
+}
+
+func (suite *PostgreSQLMigrationsTestSuite) TestReadPostgreSQLMigrations() {
+	fsys := fstest.MapFS{
+		"migrations/0002_add_column.up.sql":   {Data: []byte(`ALTER TABLE a ADD COLUMN b INT;`)},
+		"migrations/0002_add_column.down.sql": {Data: []byte(`ALTER TABLE a DROP COLUMN b;`)},
+		"migrations/0001_create_table.sql":    {Data: []byte(`CREATE TABLE a (id INT);`)},
+		"migrations/README.md":                {Data: []byte(`not a migration`)},
+	}
+
+	migrations, err := sakerhet.ReadPostgreSQLMigrations(fsys, "migrations")
+	if err != nil {
+		suite.T().Fatal(err)
+	}
+
+	assert.Equal(suite.T(), []sakerhet.PostgreSQLMigration{
+		{Version: 1, Name: "create_table", UpFile: "migrations/0001_create_table.sql"},
+		{
+			Version:  2,
+			Name:     "add_column",
+			UpFile:   "migrations/0002_add_column.up.sql",
+			DownFile: "migrations/0002_add_column.down.sql",
+		},
+	}, migrations)
+
+	fsys["migrations/0002_other_name.up.sql"] = &fstest.MapFile{Data: []byte(`SELECT 1;`)}
+
+	_, err = sakerhet.ReadPostgreSQLMigrations(fsys, "migrations")
+	assert.Error(suite.T(), err)
+}
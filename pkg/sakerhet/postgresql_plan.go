@@ -0,0 +1,229 @@
+package sakerhet
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Node of a query plan as given by EXPLAIN (FORMAT JSON), actual values are only set when analyzed
+type PostgreSQLPlanNode struct {
+	NodeType        string               `json:"Node Type"`
+	RelationName    string               `json:"Relation Name"`
+	Alias           string               `json:"Alias"`
+	IndexName       string               `json:"Index Name"`
+	StartupCost     float64              `json:"Startup Cost"`
+	TotalCost       float64              `json:"Total Cost"`
+	PlanRows        float64              `json:"Plan Rows"`
+	ActualTotalTime float64              `json:"Actual Total Time"`
+	ActualRows      float64              `json:"Actual Rows"`
+	Plans           []PostgreSQLPlanNode `json:"Plans"`
+}
+
+type PostgreSQLQueryPlan struct {
+	Plan          PostgreSQLPlanNode `json:"Plan"`
+	PlanningTime  float64            `json:"Planning Time"`
+	ExecutionTime float64            `json:"Execution Time"`
+}
+
+// All the nodes of the plan, depth first
+func (p PostgreSQLQueryPlan) Nodes() []PostgreSQLPlanNode {
+	var nodes []PostgreSQLPlanNode
+
+	var walk func(n PostgreSQLPlanNode)
+	walk = func(n PostgreSQLPlanNode) {
+		nodes = append(nodes, n)
+
+		for _, child := range n.Plans {
+			walk(child)
+		}
+	}
+
+	walk(p.Plan)
+
+	return nodes
+}
+
+// Indented tree of the plan nodes, similar to the text format of EXPLAIN
+func (p PostgreSQLQueryPlan) String() string {
+	var sb strings.Builder
+
+	var walk func(n PostgreSQLPlanNode, depth int)
+	walk = func(n PostgreSQLPlanNode, depth int) {
+		sb.WriteString(strings.Repeat("  ", depth))
+
+		if depth > 0 {
+			sb.WriteString("-> ")
+		}
+
+		sb.WriteString(n.NodeType)
+
+		if n.IndexName != "" {
+			fmt.Fprintf(&sb, " using %s", n.IndexName)
+		}
+
+		if n.RelationName != "" {
+			fmt.Fprintf(&sb, " on %s", n.RelationName)
+
+			if n.Alias != "" && n.Alias != n.RelationName {
+				fmt.Fprintf(&sb, " %s", n.Alias)
+			}
+		}
+
+		fmt.Fprintf(&sb, "  (cost=%.2f..%.2f rows=%.0f)", n.StartupCost, n.TotalCost, n.PlanRows)
+		sb.WriteString("\n")
+
+		for _, child := range n.Plans {
+			walk(child, depth+1)
+		}
+	}
+
+	walk(p.Plan, 0)
+
+	return sb.String()
+}
+
+type PostgreSQLExplainOptions struct {
+	// Execute the query to get actual times and row counts, its changes are rolled back
+	Analyze bool
+	// Planner settings applied only while explaining, e.g. enable_seqscan: off
+	Settings map[string]string
+}
+
+// Check of a query plan, returning why the plan does not satisfy it
+type PostgreSQLPlanAssertion func(plan PostgreSQLQueryPlan) error
+
+type PostgreSQLPlanExpectation struct {
+	Query      string
+	Args       []any
+	Options    PostgreSQLExplainOptions
+	Assertions []PostgreSQLPlanAssertion
+}
+
+// Explain a query, within a transaction that is rolled back when db can start one
+func (p *PostgreSQLIntegrationTester) ExplainQuery(ctx context.Context, db PostgreSQLExecutor, opts PostgreSQLExplainOptions, query string, args ...any) (*PostgreSQLQueryPlan, error) {
+	return ExplainPostgreSQLQuery(ctx, db, opts, query, args...)
+}
+
+// Explain the expected query and run all the assertions on its plan, reporting the plan on failure
+func (p *PostgreSQLIntegrationTester) AssertPlan(ctx context.Context, db PostgreSQLExecutor, expectation PostgreSQLPlanExpectation) error {
+	return AssertPostgreSQLPlan(ctx, db, expectation)
+}
+
+func ExplainPostgreSQLQuery(ctx context.Context, db PostgreSQLExecutor, opts PostgreSQLExplainOptions, query string, args ...any) (*PostgreSQLQueryPlan, error) {
+	explain := "EXPLAIN (FORMAT JSON)"
+	if opts.Analyze {
+		explain = "EXPLAIN (ANALYZE, FORMAT JSON)"
+	}
+
+	var raw []byte
+
+	if err := inRolledBackPostgreSQLTx(ctx, db, func(tx PostgreSQLExecutor) error {
+		settings := make([]string, 0, len(opts.Settings))
+		for k := range opts.Settings {
+			settings = append(settings, k)
+		}
+
+		sort.Strings(settings)
+
+		for _, k := range settings {
+			if _, err := tx.Exec(ctx, `SELECT set_config($1, $2, true);`, k, opts.Settings[k]); err != nil {
+				return fmt.Errorf("applying planner setting %s: %w", k, err)
+			}
+		}
+
+		return tx.QueryRow(ctx, fmt.Sprintf("%s %s", explain, query), args...).Scan(&raw)
+	}); err != nil {
+		return nil, err
+	}
+
+	var plans []PostgreSQLQueryPlan
+
+	if err := json.Unmarshal(raw, &plans); err != nil {
+		return nil, fmt.Errorf("parsing query plan: %w", err)
+	}
+
+	if len(plans) != 1 {
+		return nil, fmt.Errorf("expected a single query plan, got %d", len(plans))
+	}
+
+	return &plans[0], nil
+}
+
+func AssertPostgreSQLPlan(ctx context.Context, db PostgreSQLExecutor, expectation PostgreSQLPlanExpectation) error {
+	plan, err := ExplainPostgreSQLQuery(ctx, db, expectation.Options, expectation.Query, expectation.Args...)
+	if err != nil {
+		return err
+	}
+
+	var failures []string
+
+	for _, assertion := range expectation.Assertions {
+		if err := assertion(*plan); err != nil {
+			failures = append(failures, err.Error())
+		}
+	}
+
+	if len(failures) > 0 {
+		return fmt.Errorf("query plan is different than expected:\n  %s\nplan:\n%s", strings.Join(failures, "\n  "), plan)
+	}
+
+	return nil
+}
+
+// The plan scans the given index
+func UsesIndex(index string) PostgreSQLPlanAssertion {
+	return func(plan PostgreSQLQueryPlan) error {
+		for _, n := range plan.Nodes() {
+			if n.IndexName == index {
+				return nil
+			}
+		}
+
+		return fmt.Errorf("expected index %s to be used", index)
+	}
+}
+
+// The plan has no sequential scan of the given table
+func NoSeqScan(table string) PostgreSQLPlanAssertion {
+	return func(plan PostgreSQLQueryPlan) error {
+		for _, n := range plan.Nodes() {
+			if n.NodeType == "Seq Scan" && n.RelationName == table {
+				return fmt.Errorf("expected no sequential scan on %s", table)
+			}
+		}
+
+		return nil
+	}
+}
+
+// The estimated total cost of the plan is below max
+func CostBelow(max float64) PostgreSQLPlanAssertion {
+	return func(plan PostgreSQLQueryPlan) error {
+		if plan.Plan.TotalCost >= max {
+			return fmt.Errorf("expected estimated cost below %.2f, got %.2f", max, plan.Plan.TotalCost)
+		}
+
+		return nil
+	}
+}
+
+// Run fn in a transaction that is always rolled back, or directly on db when it cannot start one
+func inRolledBackPostgreSQLTx(ctx context.Context, db PostgreSQLExecutor, fn func(tx PostgreSQLExecutor) error) error {
+	tx, ok, err := beginPostgreSQLTx(ctx, db)
+	if err != nil {
+		return err
+	}
+
+	if !ok {
+		return fn(db)
+	}
+
+	defer func() {
+		_ = tx.Rollback(ctx)
+	}()
+
+	return fn(tx)
+}
@@ -0,0 +1,52 @@
+package sakerhet_test
+
+import (
+	"testing"
+
+	"github.com/averageflow/sakerhet/pkg/sakerhet"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/suite"
+)
+
+type PostgreSQLPlanTestSuite struct {
+	suite.Suite
+}
+
+func TestPostgreSQLPlanTestSuite(t *testing.T) {
+	sakerhet.SkipUnitTestsWhenIntegrationTesting(t)
+	t.Parallel()
+	suite.Run(t, new(PostgreSQLPlanTestSuite))
+}
+
+func (suite *PostgreSQLPlanTestSuite) TestPlanAssertions() {
+	plan := sakerhet.PostgreSQLQueryPlan{
+		Plan: sakerhet.PostgreSQLPlanNode{
+			NodeType:  "Nested Loop",
+			TotalCost: 42.5,
+			PlanRows:  1,
+			Plans: []sakerhet.PostgreSQLPlanNode{
+				{NodeType: "Index Scan", RelationName: "accounts", Alias: "a", IndexName: "accounts_pkey", TotalCost: 8.3, PlanRows: 1},
+				{NodeType: "Seq Scan", RelationName: "posts", Alias: "posts", TotalCost: 30, PlanRows: 10},
+			},
+		},
+	}
+
+	assert.Len(suite.T(), plan.Nodes(), 3)
+
+	assert.NoError(suite.T(), sakerhet.UsesIndex("accounts_pkey")(plan))
+	assert.EqualError(suite.T(), sakerhet.UsesIndex("posts_pkey")(plan), "expected index posts_pkey to be used")
+
+	assert.NoError(suite.T(), sakerhet.NoSeqScan("accounts")(plan))
+	assert.EqualError(suite.T(), sakerhet.NoSeqScan("posts")(plan), "expected no sequential scan on posts")
+
+	assert.NoError(suite.T(), sakerhet.CostBelow(50)(plan))
+	assert.EqualError(suite.T(), sakerhet.CostBelow(40)(plan), "expected estimated cost below 40.00, got 42.50")
+
+	assert.Equal(
+		suite.T(),
+		"Nested Loop  (cost=0.00..42.50 rows=1)\n"+
+			"  -> Index Scan using accounts_pkey on accounts a  (cost=0.00..8.30 rows=1)\n"+
+			"  -> Seq Scan on posts  (cost=0.00..30.00 rows=10)\n",
+		plan.String(),
+	)
+}
@@ -0,0 +1,162 @@
+package sakerhet
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	abstractedcontainers "github.com/averageflow/sakerhet/pkg/abstracted_containers"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/jackc/pgx/v5/stdlib"
+)
+
+// Settings of the pools created by the tester, zero values keep the pgxpool defaults
+type PostgreSQLPoolSettings struct {
+	MaxConns        int32
+	MinConns        int32
+	MaxConnLifetime time.Duration
+	MaxConnIdleTime time.Duration
+	// Called last, to change anything else of the pool configuration
+	Configure func(config *pgxpool.Config)
+}
+
+// Start the container and connect both a pgx pool and a database/sql handle to its database,
+// available as Container, Pool and SQLDB until Terminate closes them all
+func (p *PostgreSQLIntegrationTester) Start(ctx context.Context) error {
+	container, err := p.ContainerStart(ctx)
+	if err != nil {
+		return err
+	}
+
+	pool, err := p.NewPool(ctx, container.ConnectionURL)
+	if err != nil {
+		_ = container.Terminate(context.Background())
+		return err
+	}
+
+	p.Container = container
+	p.Pool = pool
+	p.SQLDB = stdlib.OpenDB(*pool.Config().ConnConfig)
+
+	return nil
+}
+
+// Close the database/sql handle and the pool, then terminate the container
+func (p *PostgreSQLIntegrationTester) Terminate(ctx context.Context) error {
+	var failures []string
+
+	if p.SQLDB != nil {
+		if err := p.SQLDB.Close(); err != nil {
+			failures = append(failures, err.Error())
+		}
+
+		p.SQLDB = nil
+	}
+
+	if p.Pool != nil {
+		p.Pool.Close()
+		p.Pool = nil
+	}
+
+	if p.Container != nil {
+		if err := p.Container.Terminate(ctx); err != nil {
+			failures = append(failures, err.Error())
+		}
+
+		p.Container = nil
+	}
+
+	if len(failures) > 0 {
+		return fmt.Errorf("terminating PostgreSQL: %s", strings.Join(failures, "; "))
+	}
+
+	return nil
+}
+
+// Capture the current state of the database started by Start under the given name,
+// closing Pool and SQLDB while copying it and opening new ones afterwards
+func (p *PostgreSQLIntegrationTester) Snapshot(ctx context.Context, name string) error {
+	return p.withConnectionsClosed(ctx, func(container *abstractedcontainers.PostgreSQLContainer) error {
+		return container.Snapshot(ctx, name)
+	})
+}
+
+// Bring the database started by Start back to the state captured by Snapshot,
+// replacing Pool and SQLDB with new ones connected to the restored database
+func (p *PostgreSQLIntegrationTester) Restore(ctx context.Context, name string) error {
+	return p.withConnectionsClosed(ctx, func(container *abstractedcontainers.PostgreSQLContainer) error {
+		return container.Restore(ctx, name)
+	})
+}
+
+// Close Pool and SQLDB around fn, reopening them even when fn fails
+func (p *PostgreSQLIntegrationTester) withConnectionsClosed(ctx context.Context, fn func(container *abstractedcontainers.PostgreSQLContainer) error) error {
+	if p.Container == nil {
+		return errors.New("PostgreSQL is not started, call Start first")
+	}
+
+	if p.SQLDB != nil {
+		if err := p.SQLDB.Close(); err != nil {
+			return err
+		}
+
+		p.SQLDB = nil
+	}
+
+	if p.Pool != nil {
+		p.Pool.Close()
+		p.Pool = nil
+	}
+
+	fnErr := fn(p.Container)
+
+	pool, err := p.NewPool(ctx, p.Container.ConnectionURL)
+	if err != nil {
+		if fnErr != nil {
+			return fmt.Errorf("%v, reconnecting: %w", fnErr, err)
+		}
+
+		return err
+	}
+
+	p.Pool = pool
+	p.SQLDB = stdlib.OpenDB(*pool.Config().ConnConfig)
+
+	return fnErr
+}
+
+// Create a pool with the pool settings of the tester
+func (p *PostgreSQLIntegrationTester) NewPool(ctx context.Context, connectionURL string) (*pgxpool.Pool, error) {
+	config, err := pgxpool.ParseConfig(connectionURL)
+	if err != nil {
+		return nil, err
+	}
+
+	if p.PoolSettings.MaxConns > 0 {
+		config.MaxConns = p.PoolSettings.MaxConns
+	}
+
+	if p.PoolSettings.MinConns > 0 {
+		config.MinConns = p.PoolSettings.MinConns
+	}
+
+	if p.PoolSettings.MaxConnLifetime > 0 {
+		config.MaxConnLifetime = p.PoolSettings.MaxConnLifetime
+	}
+
+	if p.PoolSettings.MaxConnIdleTime > 0 {
+		config.MaxConnIdleTime = p.PoolSettings.MaxConnIdleTime
+	}
+
+	if p.QueryRecorder != nil {
+		config.ConnConfig.Tracer = p.QueryRecorder
+	}
+
+	if p.PoolSettings.Configure != nil {
+		p.PoolSettings.Configure(config)
+	}
+
+	return pgxpool.NewWithConfig(ctx, config)
+}
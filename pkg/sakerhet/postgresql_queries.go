@@ -0,0 +1,189 @@
+package sakerhet
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+type PostgreSQLRecordedQuery struct {
+	SQL        string
+	Args       []any
+	CommandTag pgconn.CommandTag
+	Err        error
+	Duration   time.Duration
+}
+
+// SQL with its whitespace collapsed, so that the same statement formatted differently is counted once
+func (q PostgreSQLRecordedQuery) NormalizedSQL() string {
+	return strings.Join(strings.Fields(q.SQL), " ")
+}
+
+// pgx.QueryTracer recording every query of the connections it is set on, in the order they started.
+// It sees the queries of the helpers too, so Reset it right before running the code under test.
+type PostgreSQLQueryRecorder struct {
+	mu      sync.Mutex
+	queries []PostgreSQLRecordedQuery
+	// Incremented by Reset, so that queries started before it do not update the ones recorded after
+	generation int
+}
+
+func NewPostgreSQLQueryRecorder() *PostgreSQLQueryRecorder {
+	return &PostgreSQLQueryRecorder{}
+}
+
+type recordedQueryKey struct{}
+
+type recordedQueryStart struct {
+	generation int
+	index      int
+	start      time.Time
+}
+
+func (r *PostgreSQLQueryRecorder) TraceQueryStart(ctx context.Context, conn *pgx.Conn, data pgx.TraceQueryStartData) context.Context {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.queries = append(r.queries, PostgreSQLRecordedQuery{SQL: data.SQL, Args: data.Args})
+
+	return context.WithValue(ctx, recordedQueryKey{}, recordedQueryStart{
+		generation: r.generation,
+		index:      len(r.queries) - 1,
+		start:      time.Now(),
+	})
+}
+
+func (r *PostgreSQLQueryRecorder) TraceQueryEnd(ctx context.Context, conn *pgx.Conn, data pgx.TraceQueryEndData) {
+	started, ok := ctx.Value(recordedQueryKey{}).(recordedQueryStart)
+	if !ok {
+		return
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	// the recorder was reset while the query ran
+	if started.generation != r.generation {
+		return
+	}
+
+	r.queries[started.index].CommandTag = data.CommandTag
+	r.queries[started.index].Err = data.Err
+	r.queries[started.index].Duration = time.Since(started.start)
+}
+
+// Copy of the queries recorded since the last Reset
+func (r *PostgreSQLQueryRecorder) Queries() []PostgreSQLRecordedQuery {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return append([]PostgreSQLRecordedQuery(nil), r.queries...)
+}
+
+// Forget the recorded queries
+func (r *PostgreSQLQueryRecorder) Reset() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.queries = nil
+	r.generation++
+}
+
+func (r *PostgreSQLQueryRecorder) AssertAtMost(n int) error {
+	queries := r.Queries()
+
+	if len(queries) > n {
+		return fmt.Errorf("expected at most %d queries, got %d:\n%s", n, len(queries), formatRecordedQueries(queries))
+	}
+
+	return nil
+}
+
+// No recorded query matches the regular expression
+func (r *PostgreSQLQueryRecorder) AssertNoQueryMatching(pattern string) error {
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return err
+	}
+
+	var matching []PostgreSQLRecordedQuery
+
+	for _, q := range r.Queries() {
+		if re.MatchString(q.SQL) {
+			matching = append(matching, q)
+		}
+	}
+
+	if len(matching) > 0 {
+		return fmt.Errorf("expected no query matching %q, got %d:\n%s", pattern, len(matching), formatRecordedQueries(matching))
+	}
+
+	return nil
+}
+
+// Statements issued more than max times, by normalized SQL, whatever their arguments
+func (r *PostgreSQLQueryRecorder) RepeatedQueries(max int) map[string]int {
+	counts := make(map[string]int)
+
+	for _, q := range r.Queries() {
+		counts[q.NormalizedSQL()]++
+	}
+
+	repeated := make(map[string]int)
+
+	for statement, count := range counts {
+		if count > max {
+			repeated[statement] = count
+		}
+	}
+
+	return repeated
+}
+
+// Detect N+1 patterns: no statement is issued more than max times, such as once per row of a previous query
+func (r *PostgreSQLQueryRecorder) AssertNoRepeatedQueries(max int) error {
+	repeated := r.RepeatedQueries(max)
+	if len(repeated) == 0 {
+		return nil
+	}
+
+	statements := make([]string, 0, len(repeated))
+	for statement := range repeated {
+		statements = append(statements, statement)
+	}
+
+	sort.Strings(statements)
+
+	var sb strings.Builder
+
+	fmt.Fprintf(&sb, "expected no statement issued more than %d times, got:\n", max)
+
+	for _, statement := range statements {
+		fmt.Fprintf(&sb, "  %dx %s\n", repeated[statement], statement)
+	}
+
+	return fmt.Errorf("%s", sb.String())
+}
+
+func formatRecordedQueries(queries []PostgreSQLRecordedQuery) string {
+	var sb strings.Builder
+
+	for i, q := range queries {
+		fmt.Fprintf(&sb, "  %d. %s", i+1, q.NormalizedSQL())
+
+		if len(q.Args) > 0 {
+			fmt.Fprintf(&sb, " %v", q.Args)
+		}
+
+		sb.WriteString("\n")
+	}
+
+	return sb.String()
+}
@@ -0,0 +1,70 @@
+package sakerhet_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/averageflow/sakerhet/pkg/sakerhet"
+	"github.com/jackc/pgx/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/suite"
+)
+
+type PostgreSQLQueryRecorderTestSuite struct {
+	suite.Suite
+}
+
+func TestPostgreSQLQueryRecorderTestSuite(t *testing.T) {
+	sakerhet.SkipUnitTestsWhenIntegrationTesting(t)
+	t.Parallel()
+	suite.Run(t, new(PostgreSQLQueryRecorderTestSuite))
+}
+
+func trace(recorder *sakerhet.PostgreSQLQueryRecorder, sql string, args ...any) {
+	ctx := recorder.TraceQueryStart(context.Background(), nil, pgx.TraceQueryStartData{SQL: sql, Args: args})
+	recorder.TraceQueryEnd(ctx, nil, pgx.TraceQueryEndData{})
+}
+
+func (suite *PostgreSQLQueryRecorderTestSuite) TestQueryRecorder() {
+	recorder := sakerhet.NewPostgreSQLQueryRecorder()
+
+	trace(recorder, `SELECT user_id FROM accounts;`)
+
+	for i := 1; i <= 3; i++ {
+		trace(recorder, "SELECT title\n\t\tFROM posts WHERE user_id = $1;", i)
+	}
+
+	queries := recorder.Queries()
+
+	assert.Len(suite.T(), queries, 4)
+	assert.Equal(suite.T(), "SELECT title FROM posts WHERE user_id = $1;", queries[1].NormalizedSQL())
+	assert.Equal(suite.T(), []any{3}, queries[3].Args)
+
+	assert.NoError(suite.T(), recorder.AssertAtMost(4))
+	assert.ErrorContains(suite.T(), recorder.AssertAtMost(2), "expected at most 2 queries, got 4")
+
+	assert.NoError(suite.T(), recorder.AssertNoQueryMatching(`(?i)delete`))
+	assert.ErrorContains(suite.T(), recorder.AssertNoQueryMatching(`FROM posts`), "got 3")
+
+	assert.Equal(suite.T(), map[string]int{"SELECT title FROM posts WHERE user_id = $1;": 3}, recorder.RepeatedQueries(1))
+	assert.NoError(suite.T(), recorder.AssertNoRepeatedQueries(3))
+	assert.EqualError(
+		suite.T(),
+		recorder.AssertNoRepeatedQueries(2),
+		"expected no statement issued more than 2 times, got:\n  3x SELECT title FROM posts WHERE user_id = $1;\n",
+	)
+
+	recorder.Reset()
+
+	assert.Empty(suite.T(), recorder.Queries())
+
+	// a query still running across a Reset does not update the ones recorded after it
+	stale := recorder.TraceQueryStart(context.Background(), nil, pgx.TraceQueryStartData{SQL: `SELECT pg_sleep(1);`})
+
+	recorder.Reset()
+	trace(recorder, `SELECT user_id FROM accounts;`)
+
+	recorder.TraceQueryEnd(stale, nil, pgx.TraceQueryEndData{Err: context.Canceled})
+
+	assert.NoError(suite.T(), recorder.Queries()[0].Err)
+}
@@ -0,0 +1,147 @@
+package sakerhet
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/jackc/pgx/v5"
+)
+
+type PostgreSQLResetOptions struct {
+	// Schemas whose tables are truncated, defaults to public
+	Schemas []string
+	// Tables left untouched, as table or schema.table, the migrations table is always kept.
+	// Excluding a table referencing a truncated one is an error, as its rows would be left dangling, unless Cascade is set.
+	ExcludeTables []string
+	// Truncate with CASCADE, also emptying every table referencing a truncated one.
+	// Off by default, as the cascade reaches tables that were excluded or live in other schemas
+	// (e.g. reference data seeded once for the whole suite) and silently empties them too.
+	Cascade bool
+}
+
+// Truncate every table of the chosen schemas, restarting their sequences
+func (p *PostgreSQLIntegrationTester) ResetDatabase(ctx context.Context, db PostgreSQLExecutor, opts PostgreSQLResetOptions) error {
+	return ResetPostgreSQLDatabase(ctx, db, opts)
+}
+
+func ResetPostgreSQLDatabase(ctx context.Context, db PostgreSQLExecutor, opts PostgreSQLResetOptions) error {
+	schemas := opts.Schemas
+	if len(schemas) == 0 {
+		schemas = []string{"public"}
+	}
+
+	excluded := map[string]bool{PostgreSQLMigrationsTable: true}
+	for _, v := range opts.ExcludeTables {
+		excluded[v] = true
+	}
+
+	// one placeholder per schema rather than an array, which not every database/sql driver can bind
+	placeholders := make([]string, len(schemas))
+	args := make([]any, len(schemas))
+
+	for i, v := range schemas {
+		placeholders[i] = fmt.Sprintf("$%d", i+1)
+		args[i] = v
+	}
+
+	rows, err := db.Query(
+		ctx,
+		fmt.Sprintf(
+			`SELECT schemaname::text, tablename::text FROM pg_tables WHERE schemaname IN (%s) ORDER BY schemaname, tablename;`,
+			strings.Join(placeholders, ", "),
+		),
+		args...,
+	)
+	if err != nil {
+		return err
+	}
+
+	var tables []string
+
+	truncated := make(map[string]bool)
+
+	for rows.Next() {
+		var schema, table string
+
+		if err := rows.Scan(&schema, &table); err != nil {
+			rows.Close()
+			return err
+		}
+
+		if excluded[table] || excluded[schema+"."+table] {
+			continue
+		}
+
+		truncated[schema+"."+table] = true
+		tables = append(tables, pgx.Identifier{schema, table}.Sanitize())
+	}
+
+	rows.Close()
+
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	if len(tables) == 0 {
+		return nil
+	}
+
+	query := `TRUNCATE TABLE %s RESTART IDENTITY CASCADE;`
+
+	if !opts.Cascade {
+		query = `TRUNCATE TABLE %s RESTART IDENTITY;`
+
+		if err := checkNoDanglingReferences(ctx, db, truncated); err != nil {
+			return err
+		}
+	}
+
+	return inPostgreSQLTx(ctx, db, func(tx PostgreSQLExecutor) error {
+		_, err := tx.Exec(ctx, fmt.Sprintf(query, strings.Join(tables, ", ")))
+		return err
+	})
+}
+
+// Fail when a table that is kept has a foreign key to a truncated one
+func checkNoDanglingReferences(ctx context.Context, db PostgreSQLExecutor, truncated map[string]bool) error {
+	rows, err := db.Query(ctx, `
+		SELECT DISTINCT rn.nspname::text, r.relname::text, fn.nspname::text, f.relname::text
+		FROM pg_constraint c
+		JOIN pg_class r ON r.oid = c.conrelid
+		JOIN pg_namespace rn ON rn.oid = r.relnamespace
+		JOIN pg_class f ON f.oid = c.confrelid
+		JOIN pg_namespace fn ON fn.oid = f.relnamespace
+		WHERE c.contype = 'f'
+		ORDER BY 1, 2, 3, 4;
+	`)
+	if err != nil {
+		return err
+	}
+
+	defer rows.Close()
+
+	var dangling []string
+
+	for rows.Next() {
+		var schema, table, referencedSchema, referencedTable string
+
+		if err := rows.Scan(&schema, &table, &referencedSchema, &referencedTable); err != nil {
+			return err
+		}
+
+		if truncated[referencedSchema+"."+referencedTable] && !truncated[schema+"."+table] {
+			dangling = append(dangling, fmt.Sprintf("%s.%s references %s.%s", schema, table, referencedSchema, referencedTable))
+		}
+	}
+
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	if len(dangling) > 0 {
+		return fmt.Errorf("cannot reset tables referenced by tables that are kept:\n  %s", strings.Join(dangling, "\n  "))
+	}
+
+	return nil
+}
@@ -0,0 +1,174 @@
+package sakerhet
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// Fetch the rows of a query as a typed slice.
+// Struct types get their columns mapped to exported fields by `db` tag or by name
+// (case and underscore insensitive, so user_id maps to UserID), use pointer fields for NULL-able columns.
+// Any other type (including pgx types such as pgtype.Text) is scanned directly from a single column query.
+func FetchInto[T any](ctx context.Context, db PostgreSQLExecutor, query string, args ...any) ([]T, error) {
+	rows, err := db.Query(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	var result []T
+
+	for rows.Next() {
+		var x T
+
+		if err := ScanRowInto(rows, &x); err != nil {
+			return nil, err
+		}
+
+		result = append(result, x)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+// Row handler scanning each row into a T, to use typed values in PostgreSQLIntegrationTestExpectation
+func RowHandlerFor[T any]() func(rows pgx.Rows) (any, error) {
+	return func(rows pgx.Rows) (any, error) {
+		var x T
+
+		if err := ScanRowInto(rows, &x); err != nil {
+			return nil, err
+		}
+
+		return x, nil
+	}
+}
+
+// Scan the current row into dst, which must be a pointer, following the same rules as FetchInto
+func ScanRowInto(rows pgx.Rows, dst any) error {
+	v := reflect.ValueOf(dst)
+	if v.Kind() != reflect.Pointer || v.IsNil() {
+		return fmt.Errorf("scan destination must be a non-nil pointer, got %T", dst)
+	}
+
+	v = v.Elem()
+
+	if !isScannedAsRow(v.Type()) {
+		return rows.Scan(dst)
+	}
+
+	columns := make([]string, len(rows.FieldDescriptions()))
+	for i, fd := range rows.FieldDescriptions() {
+		columns[i] = fd.Name
+	}
+
+	fieldIndexes, err := mapColumnsToFields(v.Type(), columns)
+	if err != nil {
+		return err
+	}
+
+	targets := make([]any, len(fieldIndexes))
+	for i, idx := range fieldIndexes {
+		targets[i] = v.FieldByIndex(idx).Addr().Interface()
+	}
+
+	return rows.Scan(targets...)
+}
+
+// Structs are mapped field by field, unless they know how to scan themselves
+func isScannedAsRow(t reflect.Type) bool {
+	if t.Kind() != reflect.Struct || t == reflect.TypeOf(time.Time{}) {
+		return false
+	}
+
+	return !reflect.PointerTo(t).Implements(reflect.TypeOf((*sql.Scanner)(nil)).Elem())
+}
+
+func normalizeColumnName(name string) string {
+	return strings.ReplaceAll(strings.ToLower(name), "_", "")
+}
+
+// Index of the struct field each column will be scanned into
+func mapColumnsToFields(t reflect.Type, columns []string) ([][]int, error) {
+	tagged := make(map[string][]int)
+	named := make(map[string][]int)
+
+	collectStructFields(t, nil, tagged, named)
+
+	if len(tagged) == 0 && len(named) == 0 {
+		return nil, fmt.Errorf("%s has no exported fields to scan into", t)
+	}
+
+	result := make([][]int, len(columns))
+
+	for i, column := range columns {
+		if idx, ok := tagged[strings.ToLower(column)]; ok {
+			result[i] = idx
+			continue
+		}
+
+		if idx, ok := named[normalizeColumnName(column)]; ok {
+			result[i] = idx
+			continue
+		}
+
+		return nil, fmt.Errorf("column %q has no matching field in %s", column, t)
+	}
+
+	return result, nil
+}
+
+// Fields of the outer struct take precedence over the ones promoted from embedded structs
+func collectStructFields(t reflect.Type, parent []int, tagged, named map[string][]int) {
+	var embedded [][]int
+
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+
+		idx := make([]int, len(parent), len(parent)+1)
+		copy(idx, parent)
+		idx = append(idx, i)
+
+		if f.Anonymous && f.Type.Kind() == reflect.Struct && isScannedAsRow(f.Type) {
+			embedded = append(embedded, idx)
+			continue
+		}
+
+		if !f.IsExported() {
+			continue
+		}
+
+		tag := f.Tag.Get("db")
+		if tag == "-" {
+			continue
+		}
+
+		if tag != "" {
+			addFieldIndex(tagged, strings.ToLower(tag), idx)
+			continue
+		}
+
+		addFieldIndex(named, normalizeColumnName(f.Name), idx)
+	}
+
+	for _, idx := range embedded {
+		collectStructFields(t.FieldByIndex(idx[len(parent):]).Type, idx, tagged, named)
+	}
+}
+
+func addFieldIndex(fields map[string][]int, name string, idx []int) {
+	if _, exists := fields[name]; !exists {
+		fields[name] = idx
+	}
+}
@@ -0,0 +1,304 @@
+package sakerhet
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+const (
+	PostgreSQLSerializationFailureCode = "40001"
+	PostgreSQLDeadlockDetectedCode     = "40P01"
+	PostgreSQLLockNotAvailableCode     = "55P03"
+)
+
+// Interleaving of statements run by named sessions, each on its own connection,
+// to test locking and concurrent transactions
+type PostgreSQLScenario struct {
+	// Run in this order, a step waits for the previous step of its session to finish
+	Steps []PostgreSQLScenarioStep
+	// Wait for a session to block or for a step to finish, defaults to 5s
+	Timeout time.Duration
+}
+
+type PostgreSQLScenarioStep struct {
+	Session string
+	SQL     string
+	Args    []any
+	// Optional, run instead of SQL, e.g. to scan the rows of a SELECT ... FOR UPDATE
+	Action func(ctx context.Context, conn PostgreSQLExecutor) error
+	// The step waits on a lock held by another session: the scenario moves on once the session is blocked,
+	// and the step finishes when a later step releases the lock
+	Blocks bool
+}
+
+func (s PostgreSQLScenarioStep) String() string {
+	if s.Action != nil {
+		return fmt.Sprintf("session %s: <action>", s.Session)
+	}
+
+	return fmt.Sprintf("session %s: %s", s.Session, strings.Join(strings.Fields(s.SQL), " "))
+}
+
+type PostgreSQLScenarioStepResult struct {
+	Step PostgreSQLScenarioStep
+	Err  error
+}
+
+// Outcome of every step of a scenario, in the order of the steps
+type PostgreSQLScenarioResult struct {
+	Steps []PostgreSQLScenarioStepResult
+}
+
+// Errors of the steps of a session, nil for the successful ones
+func (r *PostgreSQLScenarioResult) SessionErrors(session string) []error {
+	var errs []error
+
+	for _, step := range r.Steps {
+		if step.Step.Session == session {
+			errs = append(errs, step.Err)
+		}
+	}
+
+	return errs
+}
+
+// Assert that a step of the session failed with an error accepted by is, such as IsSerializationFailure
+func (r *PostgreSQLScenarioResult) AssertSessionFailed(session string, is func(err error) bool) error {
+	var errs []string
+
+	for _, err := range r.SessionErrors(session) {
+		if err == nil {
+			continue
+		}
+
+		if is(err) {
+			return nil
+		}
+
+		errs = append(errs, err.Error())
+	}
+
+	if len(errs) == 0 {
+		return fmt.Errorf("expected session %s to fail, every step succeeded", session)
+	}
+
+	return fmt.Errorf("expected session %s to fail differently, got: %s", session, strings.Join(errs, "; "))
+}
+
+// Assert that every step of the session succeeded
+func (r *PostgreSQLScenarioResult) AssertSessionSucceeded(session string) error {
+	var failures []string
+
+	for _, step := range r.Steps {
+		if step.Step.Session == session && step.Err != nil {
+			failures = append(failures, fmt.Sprintf("%s: %s", step.Step, step.Err))
+		}
+	}
+
+	if len(failures) > 0 {
+		return fmt.Errorf("expected session %s to succeed, got:\n%s", session, strings.Join(failures, "\n"))
+	}
+
+	return nil
+}
+
+// PostgreSQL error with the given SQLSTATE code
+func IsPostgreSQLError(err error, code string) bool {
+	var pgErr *pgconn.PgError
+
+	return errors.As(err, &pgErr) && pgErr.Code == code
+}
+
+// Serializable or repeatable read transaction aborted because of a concurrent transaction
+func IsSerializationFailure(err error) bool {
+	return IsPostgreSQLError(err, PostgreSQLSerializationFailureCode)
+}
+
+func IsDeadlock(err error) bool {
+	return IsPostgreSQLError(err, PostgreSQLDeadlockDetectedCode)
+}
+
+// Lock not acquired by a NOWAIT statement or within lock_timeout
+func IsLockNotAvailable(err error) bool {
+	return IsPostgreSQLError(err, PostgreSQLLockNotAvailableCode)
+}
+
+// Run the steps of the scenario, each session on its own connection of the pool
+func (p *PostgreSQLIntegrationTester) RunScenario(ctx context.Context, pool *pgxpool.Pool, scenario PostgreSQLScenario) (*PostgreSQLScenarioResult, error) {
+	return RunPostgreSQLScenario(ctx, pool, scenario)
+}
+
+// Run the steps of the scenario on connections of the pool, one per session, then roll back and release them.
+// Blocked sessions are detected with pg_locks on another connection of the pool, so it needs one more than the sessions.
+// Step errors are part of the result, the returned error is for a scenario that could not run as scripted.
+func RunPostgreSQLScenario(ctx context.Context, pool *pgxpool.Pool, scenario PostgreSQLScenario) (*PostgreSQLScenarioResult, error) {
+	if scenario.Timeout <= 0 {
+		scenario.Timeout = 5 * time.Second
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+
+	sessions := make(map[string]*scenarioSession)
+
+	var order []string
+
+	defer func() {
+		// interrupt the steps still blocked when the scenario failed
+		cancel()
+
+		for _, name := range order {
+			s := sessions[name]
+
+			if s.running != nil {
+				<-s.running
+			}
+
+			_, _ = s.conn.Exec(context.Background(), `ROLLBACK;`)
+			s.conn.Release()
+		}
+	}()
+
+	result := &PostgreSQLScenarioResult{Steps: make([]PostgreSQLScenarioStepResult, len(scenario.Steps))}
+
+	for i, step := range scenario.Steps {
+		result.Steps[i].Step = step
+
+		s, ok := sessions[step.Session]
+		if !ok {
+			conn, err := pool.Acquire(ctx)
+			if err != nil {
+				return nil, fmt.Errorf("acquiring connection of session %s: %w", step.Session, err)
+			}
+
+			s = &scenarioSession{conn: conn, pid: conn.Conn().PgConn().PID()}
+			sessions[step.Session] = s
+			order = append(order, step.Session)
+		}
+
+		if err := s.wait(ctx, scenario.Timeout, result); err != nil {
+			return nil, err
+		}
+
+		s.start(ctx, i, step)
+
+		if step.Blocks {
+			if err := s.waitBlocked(ctx, pool, scenario.Timeout, result); err != nil {
+				return nil, err
+			}
+
+			continue
+		}
+
+		if err := s.wait(ctx, scenario.Timeout, result); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, name := range order {
+		if err := sessions[name].wait(ctx, scenario.Timeout, result); err != nil {
+			return nil, err
+		}
+	}
+
+	return result, nil
+}
+
+type scenarioSession struct {
+	conn *pgxpool.Conn
+	pid  uint32
+	// Outcome of the step running, nil when idle
+	running     chan error
+	runningStep int
+}
+
+func (s *scenarioSession) start(ctx context.Context, i int, step PostgreSQLScenarioStep) {
+	running := make(chan error, 1)
+
+	s.running = running
+	s.runningStep = i
+
+	go func() {
+		if step.Action != nil {
+			running <- step.Action(ctx, s.conn)
+			return
+		}
+
+		_, err := s.conn.Exec(ctx, step.SQL, step.Args...)
+		running <- err
+	}()
+}
+
+// Wait for the running step to finish, recording its outcome
+func (s *scenarioSession) wait(ctx context.Context, timeout time.Duration, result *PostgreSQLScenarioResult) error {
+	if s.running == nil {
+		return nil
+	}
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	select {
+	case err := <-s.running:
+		s.finish(err, result)
+		return nil
+	case <-timer.C:
+		return fmt.Errorf("%s still running after %s, blocked without a later step releasing it", result.Steps[s.runningStep].Step, timeout)
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Wait until the running step waits on a lock
+func (s *scenarioSession) waitBlocked(ctx context.Context, db PostgreSQLExecutor, timeout time.Duration, result *PostgreSQLScenarioResult) error {
+	step := result.Steps[s.runningStep].Step
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	// the probe needs a free connection, which a pool exhausted by the sessions never gives
+	probeCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	for {
+		var blocked bool
+
+		if err := db.QueryRow(probeCtx, `SELECT EXISTS (SELECT 1 FROM pg_locks WHERE pid = $1 AND NOT granted);`, s.pid).Scan(&blocked); err != nil {
+			if ctx.Err() == nil && errors.Is(probeCtx.Err(), context.DeadlineExceeded) {
+				return fmt.Errorf("checking whether %s is blocked took over %s, the pool may have no connection left for it: %w", step, timeout, err)
+			}
+
+			return fmt.Errorf("checking whether %s is blocked: %w", step, err)
+		}
+
+		if blocked {
+			return nil
+		}
+
+		select {
+		case err := <-s.running:
+			s.finish(err, result)
+
+			if err != nil {
+				return fmt.Errorf("expected %s to block, it failed instead: %w", step, err)
+			}
+
+			return fmt.Errorf("expected %s to block, it finished instead", step)
+		case <-timer.C:
+			return fmt.Errorf("expected %s to block, still not blocked after %s", step, timeout)
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+}
+
+func (s *scenarioSession) finish(err error, result *PostgreSQLScenarioResult) {
+	result.Steps[s.runningStep].Err = err
+	s.running = nil
+}
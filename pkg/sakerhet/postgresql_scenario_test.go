@@ -0,0 +1,62 @@
+package sakerhet_test
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/averageflow/sakerhet/pkg/sakerhet"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/suite"
+)
+
+type PostgreSQLScenarioTestSuite struct {
+	suite.Suite
+}
+
+func TestPostgreSQLScenarioTestSuite(t *testing.T) {
+	sakerhet.SkipUnitTestsWhenIntegrationTesting(t)
+	t.Parallel()
+	suite.Run(t, new(PostgreSQLScenarioTestSuite))
+}
+
+func (suite *PostgreSQLScenarioTestSuite) TestErrorCodes() {
+	serializationFailure := fmt.Errorf("committing: %w", &pgconn.PgError{Code: "40001"})
+	deadlock := &pgconn.PgError{Code: "40P01"}
+
+	assert.True(suite.T(), sakerhet.IsSerializationFailure(serializationFailure))
+	assert.False(suite.T(), sakerhet.IsSerializationFailure(deadlock))
+	assert.True(suite.T(), sakerhet.IsDeadlock(deadlock))
+	assert.True(suite.T(), sakerhet.IsLockNotAvailable(&pgconn.PgError{Code: "55P03"}))
+	assert.False(suite.T(), sakerhet.IsDeadlock(errors.New("40P01")))
+	assert.False(suite.T(), sakerhet.IsDeadlock(nil))
+}
+
+func (suite *PostgreSQLScenarioTestSuite) TestResultAssertions() {
+	result := &sakerhet.PostgreSQLScenarioResult{
+		Steps: []sakerhet.PostgreSQLScenarioStepResult{
+			{Step: sakerhet.PostgreSQLScenarioStep{Session: "A", SQL: `BEGIN;`}},
+			{Step: sakerhet.PostgreSQLScenarioStep{Session: "B", SQL: `BEGIN;`}},
+			{Step: sakerhet.PostgreSQLScenarioStep{Session: "A", SQL: `COMMIT;`}},
+			{
+				Step: sakerhet.PostgreSQLScenarioStep{Session: "B", SQL: "UPDATE accounts\n\tSET age = 1;"},
+				Err:  &pgconn.PgError{Severity: "ERROR", Code: "40001", Message: "could not serialize access due to concurrent update"},
+			},
+		},
+	}
+
+	assert.Equal(suite.T(), []error{nil, nil}, result.SessionErrors("A"))
+	assert.Len(suite.T(), result.SessionErrors("B"), 2)
+
+	assert.NoError(suite.T(), result.AssertSessionSucceeded("A"))
+	assert.EqualError(
+		suite.T(),
+		result.AssertSessionSucceeded("B"),
+		"expected session B to succeed, got:\nsession B: UPDATE accounts SET age = 1;: ERROR: could not serialize access due to concurrent update (SQLSTATE 40001)",
+	)
+
+	assert.NoError(suite.T(), result.AssertSessionFailed("B", sakerhet.IsSerializationFailure))
+	assert.ErrorContains(suite.T(), result.AssertSessionFailed("B", sakerhet.IsDeadlock), "expected session B to fail differently")
+	assert.EqualError(suite.T(), result.AssertSessionFailed("A", sakerhet.IsDeadlock), "expected session A to fail, every step succeeded")
+}
@@ -0,0 +1,342 @@
+package sakerhet
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+type PostgreSQLColumn struct {
+	Name string
+	// As shown by PostgreSQL, e.g. integer or character varying(50)
+	DataType string
+	Nullable bool
+	// Default expression, nil when the column has none
+	Default *string
+}
+
+type PostgreSQLIndex struct {
+	Name    string
+	Columns []string
+	Unique  bool
+	Primary bool
+	// CREATE INDEX statement, only compared when given in the expected schema
+	Definition string
+}
+
+const (
+	PostgreSQLPrimaryKeyConstraint = "PRIMARY KEY"
+	PostgreSQLUniqueConstraint     = "UNIQUE"
+	PostgreSQLForeignKeyConstraint = "FOREIGN KEY"
+	PostgreSQLCheckConstraint      = "CHECK"
+	PostgreSQLExclusionConstraint  = "EXCLUDE"
+)
+
+type PostgreSQLConstraint struct {
+	Name              string
+	Type              string
+	Columns           []string
+	ReferencedTable   string
+	ReferencedColumns []string
+	// Constraint clause, only compared when given in the expected schema
+	Definition string
+}
+
+// Live or expected description of a table, nil Columns, Indexes or Constraints are not compared
+type PostgreSQLTableSchema struct {
+	Name        string
+	Columns     []PostgreSQLColumn
+	Indexes     []PostgreSQLIndex
+	Constraints []PostgreSQLConstraint
+}
+
+// Read the columns, indexes and constraints of a table from the catalog
+func (p *PostgreSQLIntegrationTester) InspectTable(ctx context.Context, db PostgreSQLExecutor, table string) (*PostgreSQLTableSchema, error) {
+	return InspectPostgreSQLTable(ctx, db, table)
+}
+
+// Compare the live schema of the expected table with its description, listing every difference
+func (p *PostgreSQLIntegrationTester) AssertTableSchema(ctx context.Context, db PostgreSQLExecutor, expected PostgreSQLTableSchema) error {
+	actual, err := InspectPostgreSQLTable(ctx, db, expected.Name)
+	if err != nil {
+		return err
+	}
+
+	if differences := DiffPostgreSQLTableSchema(expected, *actual); len(differences) > 0 {
+		return fmt.Errorf("schema of table %s is different than expected:\n  %s", expected.Name, strings.Join(differences, "\n  "))
+	}
+
+	return nil
+}
+
+func InspectPostgreSQLTable(ctx context.Context, db PostgreSQLExecutor, table string) (*PostgreSQLTableSchema, error) {
+	var exists bool
+
+	if err := db.QueryRow(ctx, `SELECT to_regclass($1) IS NOT NULL;`, table).Scan(&exists); err != nil {
+		return nil, err
+	}
+
+	if !exists {
+		return nil, fmt.Errorf("table %s does not exist", table)
+	}
+
+	schema := &PostgreSQLTableSchema{Name: table, Columns: []PostgreSQLColumn{}, Indexes: []PostgreSQLIndex{}, Constraints: []PostgreSQLConstraint{}}
+
+	rows, err := db.Query(ctx, `
+		SELECT a.attname::text, format_type(a.atttypid, a.atttypmod), NOT a.attnotnull, pg_get_expr(d.adbin, d.adrelid)
+		FROM pg_attribute a
+		LEFT JOIN pg_attrdef d ON d.adrelid = a.attrelid AND d.adnum = a.attnum
+		WHERE a.attrelid = $1::text::regclass AND a.attnum > 0 AND NOT a.attisdropped
+		ORDER BY a.attnum;
+	`, table)
+	if err != nil {
+		return nil, err
+	}
+
+	for rows.Next() {
+		var c PostgreSQLColumn
+
+		if err := rows.Scan(&c.Name, &c.DataType, &c.Nullable, &c.Default); err != nil {
+			rows.Close()
+			return nil, err
+		}
+
+		schema.Columns = append(schema.Columns, c)
+	}
+
+	rows.Close()
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	rows, err = db.Query(ctx, `
+		SELECT c.relname::text, i.indisunique, i.indisprimary, pg_get_indexdef(i.indexrelid),
+			ARRAY(
+				SELECT a.attname::text
+				FROM unnest(i.indkey::int2[]) WITH ORDINALITY AS k(attnum, n)
+				JOIN pg_attribute a ON a.attrelid = i.indrelid AND a.attnum = k.attnum
+				ORDER BY k.n
+			)
+		FROM pg_index i
+		JOIN pg_class c ON c.oid = i.indexrelid
+		WHERE i.indrelid = $1::text::regclass
+		ORDER BY c.relname;
+	`, table)
+	if err != nil {
+		return nil, err
+	}
+
+	for rows.Next() {
+		var i PostgreSQLIndex
+
+		if err := rows.Scan(&i.Name, &i.Unique, &i.Primary, &i.Definition, &i.Columns); err != nil {
+			rows.Close()
+			return nil, err
+		}
+
+		schema.Indexes = append(schema.Indexes, i)
+	}
+
+	rows.Close()
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	rows, err = db.Query(ctx, `
+		SELECT con.conname::text, con.contype::text, pg_get_constraintdef(con.oid),
+			ARRAY(
+				SELECT a.attname::text
+				FROM unnest(con.conkey) WITH ORDINALITY AS k(attnum, n)
+				JOIN pg_attribute a ON a.attrelid = con.conrelid AND a.attnum = k.attnum
+				ORDER BY k.n
+			),
+			CASE WHEN con.confrelid = 0 THEN '' ELSE con.confrelid::regclass::text END,
+			ARRAY(
+				SELECT a.attname::text
+				FROM unnest(con.confkey) WITH ORDINALITY AS k(attnum, n)
+				JOIN pg_attribute a ON a.attrelid = con.confrelid AND a.attnum = k.attnum
+				ORDER BY k.n
+			)
+		FROM pg_constraint con
+		WHERE con.conrelid = $1::text::regclass
+		ORDER BY con.conname;
+	`, table)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	constraintTypes := map[string]string{
+		"p": PostgreSQLPrimaryKeyConstraint,
+		"u": PostgreSQLUniqueConstraint,
+		"f": PostgreSQLForeignKeyConstraint,
+		"c": PostgreSQLCheckConstraint,
+		"x": PostgreSQLExclusionConstraint,
+	}
+
+	for rows.Next() {
+		var c PostgreSQLConstraint
+
+		if err := rows.Scan(&c.Name, &c.Type, &c.Definition, &c.Columns, &c.ReferencedTable, &c.ReferencedColumns); err != nil {
+			return nil, err
+		}
+
+		if name, ok := constraintTypes[c.Type]; ok {
+			c.Type = name
+		}
+
+		if len(c.ReferencedColumns) == 0 {
+			c.ReferencedColumns = nil
+		}
+
+		schema.Constraints = append(schema.Constraints, c)
+	}
+
+	return schema, rows.Err()
+}
+
+// Differences between an expected and an actual table schema, in a readable form
+func DiffPostgreSQLTableSchema(expected, actual PostgreSQLTableSchema) []string {
+	var differences []string
+
+	if expected.Columns != nil {
+		actualColumns := make(map[string]PostgreSQLColumn, len(actual.Columns))
+		for _, v := range actual.Columns {
+			actualColumns[v.Name] = v
+		}
+
+		expectedNames := make(map[string]bool, len(expected.Columns))
+
+		for _, e := range expected.Columns {
+			expectedNames[e.Name] = true
+
+			a, ok := actualColumns[e.Name]
+			if !ok {
+				differences = append(differences, fmt.Sprintf("column %s is missing", e.Name))
+				continue
+			}
+
+			if e.DataType != a.DataType {
+				differences = append(differences, fmt.Sprintf("column %s: expected type %s, got %s", e.Name, e.DataType, a.DataType))
+			}
+
+			if e.Nullable != a.Nullable {
+				differences = append(differences, fmt.Sprintf("column %s: expected nullable %t, got %t", e.Name, e.Nullable, a.Nullable))
+			}
+
+			if formatSchemaDefault(e.Default) != formatSchemaDefault(a.Default) {
+				differences = append(differences, fmt.Sprintf(
+					"column %s: expected default %s, got %s",
+					e.Name,
+					formatSchemaDefault(e.Default),
+					formatSchemaDefault(a.Default),
+				))
+			}
+		}
+
+		for _, a := range actual.Columns {
+			if !expectedNames[a.Name] {
+				differences = append(differences, fmt.Sprintf("column %s is unexpected", a.Name))
+			}
+		}
+	}
+
+	if expected.Indexes != nil {
+		actualIndexes := make(map[string]PostgreSQLIndex, len(actual.Indexes))
+		for _, v := range actual.Indexes {
+			actualIndexes[v.Name] = v
+		}
+
+		expectedNames := make(map[string]bool, len(expected.Indexes))
+
+		for _, e := range expected.Indexes {
+			expectedNames[e.Name] = true
+
+			a, ok := actualIndexes[e.Name]
+			if !ok {
+				differences = append(differences, fmt.Sprintf("index %s is missing", e.Name))
+				continue
+			}
+
+			if strings.Join(e.Columns, ", ") != strings.Join(a.Columns, ", ") {
+				differences = append(differences, fmt.Sprintf("index %s: expected columns (%s), got (%s)", e.Name, strings.Join(e.Columns, ", "), strings.Join(a.Columns, ", ")))
+			}
+
+			if e.Unique != a.Unique || e.Primary != a.Primary {
+				differences = append(differences, fmt.Sprintf("index %s: expected unique %t and primary %t, got unique %t and primary %t", e.Name, e.Unique, e.Primary, a.Unique, a.Primary))
+			}
+
+			if e.Definition != "" && e.Definition != a.Definition {
+				differences = append(differences, fmt.Sprintf("index %s: expected definition %q, got %q", e.Name, e.Definition, a.Definition))
+			}
+		}
+
+		for _, a := range actual.Indexes {
+			if !expectedNames[a.Name] {
+				differences = append(differences, fmt.Sprintf("index %s is unexpected", a.Name))
+			}
+		}
+	}
+
+	if expected.Constraints != nil {
+		actualConstraints := make(map[string]PostgreSQLConstraint, len(actual.Constraints))
+		for _, v := range actual.Constraints {
+			actualConstraints[v.Name] = v
+		}
+
+		expectedNames := make(map[string]bool, len(expected.Constraints))
+
+		for _, e := range expected.Constraints {
+			expectedNames[e.Name] = true
+
+			a, ok := actualConstraints[e.Name]
+			if !ok {
+				differences = append(differences, fmt.Sprintf("constraint %s is missing", e.Name))
+				continue
+			}
+
+			if e.Type != a.Type {
+				differences = append(differences, fmt.Sprintf("constraint %s: expected type %s, got %s", e.Name, e.Type, a.Type))
+			}
+
+			if strings.Join(e.Columns, ", ") != strings.Join(a.Columns, ", ") {
+				differences = append(differences, fmt.Sprintf("constraint %s: expected columns (%s), got (%s)", e.Name, strings.Join(e.Columns, ", "), strings.Join(a.Columns, ", ")))
+			}
+
+			if formatReference(e) != formatReference(a) {
+				differences = append(differences, fmt.Sprintf("constraint %s: expected reference %s, got %s", e.Name, formatReference(e), formatReference(a)))
+			}
+
+			if e.Definition != "" && e.Definition != a.Definition {
+				differences = append(differences, fmt.Sprintf("constraint %s: expected definition %q, got %q", e.Name, e.Definition, a.Definition))
+			}
+		}
+
+		for _, a := range actual.Constraints {
+			if !expectedNames[a.Name] {
+				differences = append(differences, fmt.Sprintf("constraint %s is unexpected", a.Name))
+			}
+		}
+	}
+
+	return differences
+}
+
+func formatSchemaDefault(v *string) string {
+	if v == nil {
+		return "none"
+	}
+
+	return *v
+}
+
+func formatReference(c PostgreSQLConstraint) string {
+	if c.ReferencedTable == "" {
+		return "none"
+	}
+
+	return fmt.Sprintf("%s(%s)", c.ReferencedTable, strings.Join(c.ReferencedColumns, ", "))
+}
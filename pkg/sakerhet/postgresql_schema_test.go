@@ -0,0 +1,65 @@
+package sakerhet_test
+
+import (
+	"testing"
+
+	"github.com/averageflow/sakerhet/pkg/sakerhet"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/suite"
+)
+
+type PostgreSQLSchemaTestSuite struct {
+	suite.Suite
+}
+
+func TestPostgreSQLSchemaTestSuite(t *testing.T) {
+	sakerhet.SkipUnitTestsWhenIntegrationTesting(t)
+	t.Parallel()
+	suite.Run(t, new(PostgreSQLSchemaTestSuite))
+}
+
+func (suite *PostgreSQLSchemaTestSuite) TestDiffPostgreSQLTableSchema() {
+	defaultNow := "now()"
+
+	actual := sakerhet.PostgreSQLTableSchema{
+		Name: "accounts",
+		Columns: []sakerhet.PostgreSQLColumn{
+			{Name: "user_id", DataType: "integer"},
+			{Name: "email", DataType: "character varying(255)", Nullable: true},
+			{Name: "created_on", DataType: "timestamp with time zone", Default: &defaultNow},
+		},
+		Indexes: []sakerhet.PostgreSQLIndex{
+			{Name: "accounts_pkey", Columns: []string{"user_id"}, Unique: true, Primary: true},
+		},
+		Constraints: []sakerhet.PostgreSQLConstraint{
+			{Name: "accounts_pkey", Type: sakerhet.PostgreSQLPrimaryKeyConstraint, Columns: []string{"user_id"}},
+		},
+	}
+
+	assert.Empty(suite.T(), sakerhet.DiffPostgreSQLTableSchema(actual, actual))
+
+	// nil parts of the expected schema are not compared
+	assert.Empty(suite.T(), sakerhet.DiffPostgreSQLTableSchema(sakerhet.PostgreSQLTableSchema{Name: "accounts"}, actual))
+
+	expected := sakerhet.PostgreSQLTableSchema{
+		Name: "accounts",
+		Columns: []sakerhet.PostgreSQLColumn{
+			{Name: "user_id", DataType: "bigint"},
+			{Name: "email", DataType: "character varying(255)"},
+			{Name: "username", DataType: "text"},
+		},
+		Indexes: []sakerhet.PostgreSQLIndex{},
+		Constraints: []sakerhet.PostgreSQLConstraint{
+			{Name: "accounts_pkey", Type: sakerhet.PostgreSQLUniqueConstraint, Columns: []string{"user_id"}},
+		},
+	}
+
+	assert.Equal(suite.T(), []string{
+		"column user_id: expected type bigint, got integer",
+		"column email: expected nullable false, got true",
+		"column username is missing",
+		"column created_on is unexpected",
+		"index accounts_pkey is unexpected",
+		"constraint accounts_pkey: expected type UNIQUE, got PRIMARY KEY",
+	}, sakerhet.DiffPostgreSQLTableSchema(expected, actual))
+}
@@ -0,0 +1,301 @@
+package sakerhet
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync/atomic"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+// Common interface of *sql.DB, *sql.Conn and *sql.Tx, as opened with the pgx/stdlib driver.
+// Other drivers may work but are not tested against.
+type PostgreSQLSQLExecutor interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+// Adapt a database/sql handle to the PostgreSQLExecutor taken by the PostgreSQL helpers,
+// which do not accept *sql.DB, *sql.Conn or *sql.Tx without it, e.g. FetchInto[T](ctx, FromSQL(db), query).
+// Helpers running in a transaction begin one on *sql.DB and *sql.Conn, and a savepoint on *sql.Tx.
+func FromSQL(db PostgreSQLSQLExecutor) PostgreSQLExecutor {
+	return &sqlExecutor{db: db}
+}
+
+type sqlExecutor struct {
+	db PostgreSQLSQLExecutor
+}
+
+func (e *sqlExecutor) Exec(ctx context.Context, query string, args ...any) (pgconn.CommandTag, error) {
+	result, err := e.db.ExecContext(ctx, query, args...)
+	if err != nil {
+		return pgconn.CommandTag{}, err
+	}
+
+	// some statements, such as DDL, have no affected rows
+	affected, err := result.RowsAffected()
+	if err != nil {
+		affected = 0
+	}
+
+	// database/sql hides the command tag, so only RowsAffected of the returned one is meaningful
+	return pgconn.NewCommandTag(strconv.FormatInt(affected, 10)), nil
+}
+
+func (e *sqlExecutor) Query(ctx context.Context, query string, args ...any) (pgx.Rows, error) {
+	rows, err := e.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	return newSQLRows(rows)
+}
+
+func (e *sqlExecutor) QueryRow(ctx context.Context, query string, args ...any) pgx.Row {
+	rows, err := e.Query(ctx, query, args...)
+
+	return &sqlRow{rows: rows, err: err}
+}
+
+func (e *sqlExecutor) beginTx(ctx context.Context) (postgreSQLTx, bool, error) {
+	if tx, ok := e.db.(*sql.Tx); ok {
+		return beginSQLSavepoint(ctx, tx)
+	}
+
+	starter, ok := e.db.(interface {
+		BeginTx(ctx context.Context, opts *sql.TxOptions) (*sql.Tx, error)
+	})
+	if !ok {
+		return nil, false, nil
+	}
+
+	tx, err := starter.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, true, err
+	}
+
+	return &sqlTx{sqlExecutor: sqlExecutor{db: tx}, tx: tx}, true, nil
+}
+
+type sqlTx struct {
+	sqlExecutor
+	tx *sql.Tx
+}
+
+func (t *sqlTx) Commit(ctx context.Context) error {
+	return t.tx.Commit()
+}
+
+func (t *sqlTx) Rollback(ctx context.Context) error {
+	return t.tx.Rollback()
+}
+
+var sqlSavepointCounter int64
+
+// Savepoint within a *sql.Tx, standing in for a nested transaction as pgx.Tx does with Begin
+type sqlSavepoint struct {
+	sqlExecutor
+	tx   *sql.Tx
+	name string
+	done bool
+}
+
+func beginSQLSavepoint(ctx context.Context, tx *sql.Tx) (postgreSQLTx, bool, error) {
+	name := fmt.Sprintf("sakerhet_sp_%d", atomic.AddInt64(&sqlSavepointCounter, 1))
+
+	if _, err := tx.ExecContext(ctx, fmt.Sprintf(`SAVEPOINT %s;`, name)); err != nil {
+		return nil, true, err
+	}
+
+	return &sqlSavepoint{sqlExecutor: sqlExecutor{db: tx}, tx: tx, name: name}, true, nil
+}
+
+func (s *sqlSavepoint) Commit(ctx context.Context) error {
+	if s.done {
+		return sql.ErrTxDone
+	}
+
+	s.done = true
+
+	_, err := s.tx.ExecContext(ctx, fmt.Sprintf(`RELEASE SAVEPOINT %s;`, s.name))
+	return err
+}
+
+// Rolling back to a released savepoint would fail the whole transaction, so it is only done once
+func (s *sqlSavepoint) Rollback(ctx context.Context) error {
+	if s.done {
+		return sql.ErrTxDone
+	}
+
+	s.done = true
+
+	_, err := s.tx.ExecContext(ctx, fmt.Sprintf(`ROLLBACK TO SAVEPOINT %s;`, s.name))
+	return err
+}
+
+// pgx.Row over the first row of the result, with ErrNoRows from pgx so that callers handle both drivers alike
+type sqlRow struct {
+	rows pgx.Rows
+	err  error
+}
+
+func (r *sqlRow) Scan(dest ...any) error {
+	if r.err != nil {
+		return r.err
+	}
+
+	defer r.rows.Close()
+
+	if !r.rows.Next() {
+		if err := r.rows.Err(); err != nil {
+			return err
+		}
+
+		return pgx.ErrNoRows
+	}
+
+	if err := r.rows.Scan(dest...); err != nil {
+		return err
+	}
+
+	r.rows.Close()
+
+	return r.rows.Err()
+}
+
+// pgx.Rows over database/sql rows, without the raw values, command tag and connection only pgx has
+type sqlRows struct {
+	rows   *sql.Rows
+	fields []pgconn.FieldDescription
+	types  *pgtype.Map
+}
+
+func newSQLRows(rows *sql.Rows) (*sqlRows, error) {
+	columnTypes, err := rows.ColumnTypes()
+	if err != nil {
+		_ = rows.Close()
+		return nil, err
+	}
+
+	types := pgtype.NewMap()
+
+	fields := make([]pgconn.FieldDescription, len(columnTypes))
+
+	for i, ct := range columnTypes {
+		fields[i] = pgconn.FieldDescription{Name: ct.Name(), DataTypeOID: sqlColumnTypeOID(types, ct.DatabaseTypeName())}
+	}
+
+	return &sqlRows{rows: rows, fields: fields, types: types}, nil
+}
+
+// Drivers name the column types after pg_type, or give their OID when they do not know them
+func sqlColumnTypeOID(types *pgtype.Map, name string) uint32 {
+	if oid, err := strconv.ParseUint(name, 10, 32); err == nil {
+		return uint32(oid)
+	}
+
+	if t, ok := types.TypeForName(strings.ToLower(name)); ok {
+		return t.OID
+	}
+
+	return 0
+}
+
+func (r *sqlRows) Close() {
+	_ = r.rows.Close()
+}
+
+func (r *sqlRows) Err() error {
+	return r.rows.Err()
+}
+
+func (r *sqlRows) CommandTag() pgconn.CommandTag {
+	return pgconn.CommandTag{}
+}
+
+func (r *sqlRows) FieldDescriptions() []pgconn.FieldDescription {
+	return r.fields
+}
+
+func (r *sqlRows) Next() bool {
+	return r.rows.Next()
+}
+
+// Destinations database/sql cannot scan into, such as slices for arrays, are scanned through the pgx type map
+func (r *sqlRows) Scan(dest ...any) error {
+	targets := make([]any, len(dest))
+
+	for i, d := range dest {
+		targets[i] = d
+
+		if _, ok := d.(sql.Scanner); ok {
+			continue
+		}
+
+		t := reflect.TypeOf(d)
+		if t == nil || t.Kind() != reflect.Pointer {
+			continue
+		}
+
+		switch t.Elem().Kind() {
+		case reflect.Slice:
+			if t.Elem().Elem().Kind() != reflect.Uint8 {
+				targets[i] = r.types.SQLScanner(d)
+			}
+		case reflect.Map:
+			targets[i] = r.types.SQLScanner(d)
+		}
+	}
+
+	return r.rows.Scan(targets...)
+}
+
+func (r *sqlRows) Values() ([]any, error) {
+	values := make([]any, len(r.fields))
+	targets := make([]any, len(r.fields))
+
+	for i := range values {
+		targets[i] = &values[i]
+	}
+
+	if err := r.rows.Scan(targets...); err != nil {
+		return nil, err
+	}
+
+	return values, nil
+}
+
+func (r *sqlRows) RawValues() [][]byte {
+	return nil
+}
+
+func (r *sqlRows) Conn() *pgx.Conn {
+	return nil
+}
+
+// Transaction of either driver, as used by the helpers
+type postgreSQLTx interface {
+	PostgreSQLExecutor
+	Commit(ctx context.Context) error
+	Rollback(ctx context.Context) error
+}
+
+// Begin a transaction on db when it can start one, reporting false when it cannot
+func beginPostgreSQLTx(ctx context.Context, db PostgreSQLExecutor) (postgreSQLTx, bool, error) {
+	switch starter := db.(type) {
+	case PostgreSQLTxStarter:
+		tx, err := starter.Begin(ctx)
+		return tx, true, err
+	case *sqlExecutor:
+		return starter.beginTx(ctx)
+	}
+
+	return nil, false, nil
+}
@@ -0,0 +1,293 @@
+package sakerhet
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// Column values of an expected table row, plain values are compared for equality, PostgreSQLValueMatcher values are matched
+type PostgreSQLTableRow map[string]any
+
+// Predicate on a column value, usable in place of a plain value in a PostgreSQLTableRow
+type PostgreSQLValueMatcher struct {
+	Description string
+	Match       func(v any) bool
+}
+
+func (m PostgreSQLValueMatcher) String() string {
+	return fmt.Sprintf("<%s>", m.Description)
+}
+
+func MatchValue(description string, match func(v any) bool) PostgreSQLValueMatcher {
+	return PostgreSQLValueMatcher{Description: description, Match: match}
+}
+
+func AnyValue() PostgreSQLValueMatcher {
+	return MatchValue("any", func(v any) bool { return true })
+}
+
+func AnyNonNull() PostgreSQLValueMatcher {
+	return MatchValue("any non-null", func(v any) bool { return v != nil })
+}
+
+type PostgreSQLTableAssertOptions struct {
+	// Compare the rows in the order given by OrderBy instead of as a multiset
+	Ordered bool
+	// Columns sorting the selected rows, required for ordered comparisons
+	OrderBy []string
+	// Columns of the expected rows left out of the comparison
+	IgnoreColumns []string
+}
+
+// Assert the rows of a table, selecting only the columns named in the expected rows.
+// A column missing from some of the expected rows matches any value in those rows.
+func (p *PostgreSQLIntegrationTester) AssertTableRows(ctx context.Context, db PostgreSQLExecutor, table string, expected []PostgreSQLTableRow, opts PostgreSQLTableAssertOptions) error {
+	return AssertPostgreSQLTableRows(ctx, db, table, expected, opts)
+}
+
+func AssertPostgreSQLTableRows(ctx context.Context, db PostgreSQLExecutor, table string, expected []PostgreSQLTableRow, opts PostgreSQLTableAssertOptions) error {
+	if opts.Ordered && len(opts.OrderBy) == 0 {
+		return fmt.Errorf("ordered comparison of table %s needs OrderBy columns", table)
+	}
+
+	columns := expectedTableColumns(expected, opts.IgnoreColumns)
+
+	received, err := selectTableRows(ctx, db, table, columns, opts.OrderBy)
+	if err != nil {
+		return err
+	}
+
+	if len(columns) == 0 && len(received) > 0 {
+		columns = sortedRowColumns(received[0])
+	}
+
+	var diff tableDiff
+
+	if opts.Ordered {
+		diff = orderedTableDiff(expected, received, columns)
+	} else {
+		diff = unorderedTableDiff(expected, received, columns)
+	}
+
+	if len(diff) > 0 {
+		return fmt.Errorf("table %s is different than expected:\n%s", table, diff.render(columns))
+	}
+
+	return nil
+}
+
+func expectedTableColumns(expected []PostgreSQLTableRow, ignoreColumns []string) []string {
+	ignored := make(map[string]bool)
+	for _, v := range ignoreColumns {
+		ignored[v] = true
+	}
+
+	seen := make(map[string]bool)
+
+	var columns []string
+
+	for _, row := range expected {
+		for column := range row {
+			if !ignored[column] && !seen[column] {
+				seen[column] = true
+				columns = append(columns, column)
+			}
+		}
+	}
+
+	sort.Strings(columns)
+
+	return columns
+}
+
+func sortedRowColumns(row PostgreSQLTableRow) []string {
+	columns := make([]string, 0, len(row))
+	for column := range row {
+		columns = append(columns, column)
+	}
+
+	sort.Strings(columns)
+
+	return columns
+}
+
+func selectTableRows(ctx context.Context, db PostgreSQLExecutor, table string, columns, orderBy []string) ([]PostgreSQLTableRow, error) {
+	selected := "*"
+
+	if len(columns) > 0 {
+		quoted := make([]string, len(columns))
+		for i, v := range columns {
+			quoted[i] = pgx.Identifier{v}.Sanitize()
+		}
+
+		selected = strings.Join(quoted, ", ")
+	}
+
+	query := fmt.Sprintf(`SELECT %s FROM %s`, selected, quoteQualifiedIdentifier(table))
+
+	if len(orderBy) > 0 {
+		quoted := make([]string, len(orderBy))
+		for i, v := range orderBy {
+			quoted[i] = pgx.Identifier{v}.Sanitize()
+		}
+
+		query += fmt.Sprintf(` ORDER BY %s`, strings.Join(quoted, ", "))
+	}
+
+	rows, err := db.Query(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	var result []PostgreSQLTableRow
+
+	for rows.Next() {
+		values, err := rows.Values()
+		if err != nil {
+			return nil, err
+		}
+
+		row := make(PostgreSQLTableRow, len(values))
+		for i, fd := range rows.FieldDescriptions() {
+			row[fd.Name] = values[i]
+		}
+
+		result = append(result, row)
+	}
+
+	return result, rows.Err()
+}
+
+func tableRowMatches(expected, received PostgreSQLTableRow, columns []string) bool {
+	for _, column := range columns {
+		e, ok := expected[column]
+		if !ok {
+			continue
+		}
+
+		if !tableValueMatches(e, received[column]) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Plain values are compared as by UnorderedEqual, so that 25 equals int32(25) or a numeric 25 but not a bigint 26,
+// also within the arrays and objects of array and json columns
+func tableValueMatches(expected, received any) bool {
+	if m, ok := expected.(PostgreSQLValueMatcher); ok {
+		return m.Match(received)
+	}
+
+	return valuesEqual(reflect.ValueOf(expected), reflect.ValueOf(received), nil)
+}
+
+type tableDiffLine struct {
+	marker string
+	row    PostgreSQLTableRow
+}
+
+type tableDiff []tableDiffLine
+
+func unorderedTableDiff(expected, received []PostgreSQLTableRow, columns []string) tableDiff {
+	matchedExpected, matchedReceived := matchByEquality(expected, received, func(e, r PostgreSQLTableRow) bool {
+		return tableRowMatches(e, r, columns)
+	})
+
+	var diff tableDiff
+
+	for i, v := range expected {
+		if !matchedExpected[i] {
+			diff = append(diff, tableDiffLine{marker: "-", row: v})
+		}
+	}
+
+	for i, v := range received {
+		if !matchedReceived[i] {
+			diff = append(diff, tableDiffLine{marker: "+", row: v})
+		}
+	}
+
+	return diff
+}
+
+func orderedTableDiff(expected, received []PostgreSQLTableRow, columns []string) tableDiff {
+	var diff tableDiff
+
+	for i := 0; i < len(expected) || i < len(received); i++ {
+		switch {
+		case i >= len(received):
+			diff = append(diff, tableDiffLine{marker: fmt.Sprintf("-%d", i+1), row: expected[i]})
+		case i >= len(expected):
+			diff = append(diff, tableDiffLine{marker: fmt.Sprintf("+%d", i+1), row: received[i]})
+		case !tableRowMatches(expected[i], received[i], columns):
+			diff = append(
+				diff,
+				tableDiffLine{marker: fmt.Sprintf("-%d", i+1), row: expected[i]},
+				tableDiffLine{marker: fmt.Sprintf("+%d", i+1), row: received[i]},
+			)
+		}
+	}
+
+	return diff
+}
+
+// Aligned table of the differing rows, - for expected rows and + for received ones
+func (d tableDiff) render(columns []string) string {
+	cells := make([][]string, len(d)+1)
+	cells[0] = append([]string{""}, columns...)
+
+	for i, line := range d {
+		cells[i+1] = []string{line.marker}
+
+		for _, column := range columns {
+			v, ok := line.row[column]
+
+			switch {
+			case !ok:
+				cells[i+1] = append(cells[i+1], "<any>")
+			case v == nil:
+				cells[i+1] = append(cells[i+1], "NULL")
+			default:
+				cells[i+1] = append(cells[i+1], formatValue(reflect.ValueOf(v), nil))
+			}
+		}
+	}
+
+	widths := make([]int, len(columns)+1)
+
+	for _, row := range cells {
+		for i, v := range row {
+			if len(v) > widths[i] {
+				widths[i] = len(v)
+			}
+		}
+	}
+
+	var sb strings.Builder
+
+	for _, row := range cells {
+		var line strings.Builder
+
+		for i, v := range row {
+			if i > 0 {
+				line.WriteString(" | ")
+			}
+
+			fmt.Fprintf(&line, "%-*s", widths[i], v)
+		}
+
+		sb.WriteString(strings.TrimRight(line.String(), " "))
+		sb.WriteString("\n")
+	}
+
+	return sb.String()
+}
@@ -0,0 +1,130 @@
+package sakerhet
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	abstractedcontainers "github.com/averageflow/sakerhet/pkg/abstracted_containers"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// Create a template database, set up once by setup (e.g. with InitPostgreSQLSchema or MigratePostgreSQLUp),
+// to be cloned for each test with NewDatabaseFromTemplate.
+// Connections to the template are disallowed afterwards, as PostgreSQL cannot clone a database in use.
+func (p *PostgreSQLIntegrationTester) CreateTemplateDatabase(ctx context.Context, container *abstractedcontainers.PostgreSQLContainer, template string, setup func(ctx context.Context, db PostgreSQLExecutor) error) error {
+	if err := container.WithMaintenanceConn(ctx, func(admin *pgx.Conn) error {
+		_, err := admin.Exec(ctx, fmt.Sprintf(`CREATE DATABASE %s;`, pgx.Identifier{template}.Sanitize()))
+		return err
+	}); err != nil {
+		return fmt.Errorf("creating template database %s: %w", template, err)
+	}
+
+	if err := setUpTemplateDatabase(ctx, container, template, setup); err != nil {
+		// not left behind half set up, so that the template can be created again
+		if dropErr := DropPostgreSQLDatabase(context.Background(), container, template); dropErr != nil {
+			return fmt.Errorf("%w (dropping template database %s: %v)", err, template, dropErr)
+		}
+
+		return err
+	}
+
+	return nil
+}
+
+func setUpTemplateDatabase(ctx context.Context, container *abstractedcontainers.PostgreSQLContainer, template string, setup func(ctx context.Context, db PostgreSQLExecutor) error) error {
+	conn, err := pgx.Connect(ctx, container.ConnectionURLForDB(template))
+	if err != nil {
+		return err
+	}
+
+	if err := setup(ctx, conn); err != nil {
+		_ = conn.Close(ctx)
+		return fmt.Errorf("setting up template database %s: %w", template, err)
+	}
+
+	if err := conn.Close(ctx); err != nil {
+		return err
+	}
+
+	return container.WithMaintenanceConn(ctx, func(admin *pgx.Conn) error {
+		_, err := admin.Exec(ctx, fmt.Sprintf(
+			`ALTER DATABASE %s WITH IS_TEMPLATE true ALLOW_CONNECTIONS false;`,
+			pgx.Identifier{template}.Sanitize(),
+		))
+		return err
+	})
+}
+
+// Create a fresh copy of the template database for the test, returning a pool connected to it.
+// The pool is closed and the database dropped when the test finishes.
+func (p *PostgreSQLIntegrationTester) NewDatabaseFromTemplate(ctx context.Context, t testing.TB, container *abstractedcontainers.PostgreSQLContainer, template string) *pgxpool.Pool {
+	t.Helper()
+
+	db := fmt.Sprintf("test_%s", strings.ReplaceAll(uuid.NewString(), "-", ""))
+
+	if err := container.WithMaintenanceConn(ctx, func(admin *pgx.Conn) error {
+		_, err := admin.Exec(ctx, fmt.Sprintf(
+			`CREATE DATABASE %s TEMPLATE %s;`,
+			pgx.Identifier{db}.Sanitize(),
+			pgx.Identifier{template}.Sanitize(),
+		))
+		return err
+	}); err != nil {
+		t.Fatalf("creating database from template %s: %v", template, err)
+	}
+
+	// registered before connecting, so that the database is dropped even when connecting fails
+	t.Cleanup(func() {
+		if err := DropPostgreSQLDatabase(context.Background(), container, db); err != nil {
+			t.Errorf("dropping database %s: %v", db, err)
+		}
+	})
+
+	dbPool, err := p.NewPool(ctx, container.ConnectionURLForDB(db))
+	if err != nil {
+		t.Fatalf("connecting to database %s: %v", db, err)
+	}
+
+	// cleanups run last in first out, closing the pool before the database is dropped
+	t.Cleanup(dbPool.Close)
+
+	return dbPool
+}
+
+// Drop a database of the container, including template databases, terminating the connections still open to it
+func DropPostgreSQLDatabase(ctx context.Context, container *abstractedcontainers.PostgreSQLContainer, db string) error {
+	return container.WithMaintenanceConn(ctx, func(admin *pgx.Conn) error {
+		var isTemplate bool
+
+		err := admin.QueryRow(ctx, `SELECT datistemplate FROM pg_database WHERE datname = $1;`, db).Scan(&isTemplate)
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil
+		}
+
+		if err != nil {
+			return err
+		}
+
+		if isTemplate {
+			if _, err := admin.Exec(ctx, fmt.Sprintf(`ALTER DATABASE %s WITH IS_TEMPLATE false;`, pgx.Identifier{db}.Sanitize())); err != nil {
+				return err
+			}
+		}
+
+		if _, err := admin.Exec(
+			ctx,
+			`SELECT pg_terminate_backend(pid) FROM pg_stat_activity WHERE datname = $1 AND pid <> pg_backend_pid();`,
+			db,
+		); err != nil {
+			return err
+		}
+
+		_, err = admin.Exec(ctx, fmt.Sprintf(`DROP DATABASE IF EXISTS %s;`, pgx.Identifier{db}.Sanitize()))
+		return err
+	})
+}
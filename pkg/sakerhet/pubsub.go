@@ -186,12 +186,8 @@ func ExpectGCPMessagesInSub(ctx context.Context, client *pubsub.Client, subscrip
 		return fmt.Errorf("sub.Receive: %v", err)
 	}
 
-	if !UnorderedEqual(expectedData, receivedData) {
-		return fmt.Errorf(
-			"received data is different than expected:\n received %v\n expected %v\n",
-			toReadableSliceOfStrings(receivedData),
-			toReadableSliceOfStrings(expectedData),
-		)
+	if diff := UnorderedDiff(toReadableSliceOfStrings(expectedData), toReadableSliceOfStrings(receivedData)); !diff.Equal() {
+		return fmt.Errorf("received data is different than expected:\n%s", diff)
 	}
 
 	return nil
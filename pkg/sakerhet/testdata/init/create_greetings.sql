@@ -0,0 +1,2 @@
+CREATE TABLE greetings (message TEXT NOT NULL);
+INSERT INTO greetings (message) VALUES ('hej');
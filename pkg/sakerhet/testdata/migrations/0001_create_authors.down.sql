@@ -0,0 +1,2 @@
+DROP TABLE authors;
+DROP FUNCTION trim_author_name();
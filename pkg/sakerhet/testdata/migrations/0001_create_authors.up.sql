@@ -0,0 +1,15 @@
+CREATE TABLE authors (
+    author_id serial PRIMARY KEY,
+    name VARCHAR ( 100 ) NOT NULL
+);
+
+-- keep the name tidy; semicolons in comments are fine
+CREATE FUNCTION trim_author_name() RETURNS trigger AS $$
+BEGIN
+    NEW.name := trim(NEW.name);
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER authors_trim_name BEFORE INSERT ON authors
+    FOR EACH ROW EXECUTE FUNCTION trim_author_name();
@@ -0,0 +1 @@
+ALTER TABLE authors ADD COLUMN country VARCHAR ( 2 ) NOT NULL DEFAULT 'SE';
@@ -3,7 +3,10 @@ package sakerhet
 import (
 	"fmt"
 	"os"
+	"reflect"
+	"sort"
 	"strconv"
+	"strings"
 	"testing"
 	"time"
 )
@@ -11,26 +14,515 @@ import (
 const (
 	SakerhetRunIntegrationTestsEnvVar      = "SAKERHET_RUN_INTEGRATION_TESTS"
 	SakerhetIntegrationTestsTimeoutSeconds = "SAKERHET_INTEGRATION_TEST_TIMEOUT"
+	SakerhetUpdateGoldenFilesEnvVar        = "SAKERHET_UPDATE_GOLDEN_FILES"
 )
 
+// Options of an unordered comparison, the zero value compares elements by deep equality, following pointers
+type UnorderedOptions[T any] struct {
+	// Elements are considered equal when their keys are equal
+	Key func(v T) string
+	// Elements are considered equal when Equal returns true, takes precedence over Key
+	Equal func(expected, received T) bool
+	// Struct fields (by name or `db` tag, so created_on matches CreatedOn), map keys or slice items (as [i]) left out of the comparison
+	IgnoreFields []string
+}
+
+// Compare two slices regardless of order, duplicated elements must appear the same number of times in both
 func UnorderedEqual[T any](first, second []T) bool {
-	if len(first) != len(second) {
+	return UnorderedDiff(first, second).Equal()
+}
+
+// Compare two slices regardless of order, considering elements equal when equal returns true
+func UnorderedEqualFunc[T any](first, second []T, equal func(a, b T) bool) bool {
+	return UnorderedDiffWith(first, second, UnorderedOptions[T]{Equal: equal}).Equal()
+}
+
+// Compare two slices regardless of order, considering elements equal when their keys are equal
+func UnorderedEqualByKey[T any, K comparable](first, second []T, key func(v T) K) bool {
+	return UnorderedDiffWith(first, second, UnorderedOptions[T]{
+		Key: func(v T) string { return fmt.Sprintf("%#v", key(v)) },
+	}).Equal()
+}
+
+// Compare two slices regardless of order, leaving the given fields out of the comparison
+func UnorderedEqualIgnoringFields[T any](first, second []T, fields ...string) bool {
+	return UnorderedDiffWith(first, second, UnorderedOptions[T]{IgnoreFields: fields}).Equal()
+}
+
+type UnorderedFieldDifference struct {
+	Field    string
+	Expected string
+	Received string
+}
+
+// Pair of elements that only differ in some of their fields
+type UnorderedMismatch[T any] struct {
+	Expected T
+	Received T
+	Fields   []UnorderedFieldDifference
+}
+
+type UnorderedDifference[T any] struct {
+	// Expected elements that were not received
+	Missing []T
+	// Received elements that were not expected
+	Unexpected []T
+	// Expected elements for which a received element with some equal fields exists
+	Mismatches []UnorderedMismatch[T]
+}
+
+func (d UnorderedDifference[T]) Equal() bool {
+	return len(d.Missing) == 0 && len(d.Unexpected) == 0 && len(d.Mismatches) == 0
+}
+
+func (d UnorderedDifference[T]) String() string {
+	var sb strings.Builder
+
+	if len(d.Missing) > 0 {
+		sb.WriteString("missing (expected but not received):\n")
+
+		for _, v := range d.Missing {
+			fmt.Fprintf(&sb, "  - %+v\n", v)
+		}
+	}
+
+	if len(d.Unexpected) > 0 {
+		sb.WriteString("unexpected (received but not expected):\n")
+
+		for _, v := range d.Unexpected {
+			fmt.Fprintf(&sb, "  + %+v\n", v)
+		}
+	}
+
+	if len(d.Mismatches) > 0 {
+		sb.WriteString("different (expected vs received):\n")
+
+		for _, v := range d.Mismatches {
+			fmt.Fprintf(&sb, "  - %+v\n  + %+v\n", v.Expected, v.Received)
+
+			for _, f := range v.Fields {
+				fmt.Fprintf(&sb, "    %s: expected %s, received %s\n", f.Field, f.Expected, f.Received)
+			}
+		}
+	}
+
+	return sb.String()
+}
+
+// Compare two slices regardless of order, reporting which elements are missing, which are unexpected
+// and which fields differ for elements that only partially match.
+// Duplicated elements must appear the same number of times in both slices.
+func UnorderedDiff[T any](expected, received []T) UnorderedDifference[T] {
+	return UnorderedDiffWith(expected, received, UnorderedOptions[T]{})
+}
+
+// Same as UnorderedDiff, with custom equality and ignored fields
+func UnorderedDiffWith[T any](expected, received []T, opts UnorderedOptions[T]) UnorderedDifference[T] {
+	ignored := make(map[string]bool)
+	for _, v := range opts.IgnoreFields {
+		ignored[normalizeColumnName(v)] = true
+	}
+
+	var matchedExpected, matchedReceived []bool
+
+	switch {
+	case opts.Equal != nil:
+		matchedExpected, matchedReceived = matchByEquality(expected, received, opts.Equal)
+	case opts.Key != nil:
+		matchedExpected, matchedReceived = matchByKey(expected, received, opts.Key, func(e, r T) bool { return true })
+	default:
+		// the keys only narrow down the candidates, as different values can share a key
+		matchedExpected, matchedReceived = matchByKey(
+			expected,
+			received,
+			func(v T) string { return matchingKey(reflect.ValueOf(&v).Elem(), ignored) },
+			func(e, r T) bool { return valuesEqual(reflect.ValueOf(&e).Elem(), reflect.ValueOf(&r).Elem(), ignored) },
+		)
+	}
+
+	var missing, unexpected []T
+
+	for i, v := range expected {
+		if !matchedExpected[i] {
+			missing = append(missing, v)
+		}
+	}
+
+	for i, v := range received {
+		if !matchedReceived[i] {
+			unexpected = append(unexpected, v)
+		}
+	}
+
+	return pairNearMatches(missing, unexpected, ignored)
+}
+
+// Match elements with the same key for which equal also holds
+func matchByKey[T any](expected, received []T, key func(v T) string, equal func(expected, received T) bool) ([]bool, []bool) {
+	matchedExpected := make([]bool, len(expected))
+	matchedReceived := make([]bool, len(received))
+
+	remaining := make(map[string][]int)
+
+	for i, v := range received {
+		k := key(v)
+		remaining[k] = append(remaining[k], i)
+	}
+
+	for i, v := range expected {
+		k := key(v)
+
+		for j, r := range remaining[k] {
+			if !equal(v, received[r]) {
+				continue
+			}
+
+			matchedExpected[i] = true
+			matchedReceived[r] = true
+			remaining[k] = append(remaining[k][:j:j], remaining[k][j+1:]...)
+
+			break
+		}
+	}
+
+	return matchedExpected, matchedReceived
+}
+
+// Maximum matching between expected and received elements, as equal is not necessarily transitive
+func matchByEquality[T any](expected, received []T, equal func(expected, received T) bool) ([]bool, []bool) {
+	receivedMatch := make([]int, len(received))
+	for i := range receivedMatch {
+		receivedMatch[i] = -1
+	}
+
+	var tryMatch func(e int, visited []bool) bool
+
+	tryMatch = func(e int, visited []bool) bool {
+		for r := range received {
+			if visited[r] || !equal(expected[e], received[r]) {
+				continue
+			}
+
+			visited[r] = true
+
+			if receivedMatch[r] == -1 || tryMatch(receivedMatch[r], visited) {
+				receivedMatch[r] = e
+				return true
+			}
+		}
+
 		return false
 	}
 
-	exists := make(map[string]bool)
+	matchedExpected := make([]bool, len(expected))
+	matchedReceived := make([]bool, len(received))
+
+	for e := range expected {
+		tryMatch(e, make([]bool, len(received)))
+	}
+
+	for r, e := range receivedMatch {
+		if e != -1 {
+			matchedExpected[e] = true
+			matchedReceived[r] = true
+		}
+	}
+
+	return matchedExpected, matchedReceived
+}
+
+// Pair each missing element with the unexpected element sharing the most fields with it
+func pairNearMatches[T any](missing, unexpected []T, ignored map[string]bool) UnorderedDifference[T] {
+	var result UnorderedDifference[T]
+
+	paired := make([]bool, len(unexpected))
+
+	for _, m := range missing {
+		best := -1
+
+		var bestFields []UnorderedFieldDifference
+
+		for i, u := range unexpected {
+			if paired[i] {
+				continue
+			}
+
+			fields, ok := fieldDifferences(reflect.ValueOf(&m).Elem(), reflect.ValueOf(&u).Elem(), ignored)
+			if ok && (best == -1 || len(fields) < len(bestFields)) {
+				best = i
+				bestFields = fields
+			}
+		}
+
+		if best == -1 {
+			result.Missing = append(result.Missing, m)
+			continue
+		}
+
+		paired[best] = true
+		result.Mismatches = append(result.Mismatches, UnorderedMismatch[T]{
+			Expected: m,
+			Received: unexpected[best],
+			Fields:   bestFields,
+		})
+	}
+
+	for i, u := range unexpected {
+		if !paired[i] {
+			result.Unexpected = append(result.Unexpected, u)
+		}
+	}
+
+	return result
+}
+
+// Differing fields of two values of the same composite type, ok when at least one field is equal
+func fieldDifferences(expected, received reflect.Value, ignored map[string]bool) ([]UnorderedFieldDifference, bool) {
+	names, expectedFields := fieldsOf(expected, ignored)
+	_, receivedFields := fieldsOf(received, ignored)
+
+	if len(expectedFields) == 0 || len(expectedFields) != len(receivedFields) {
+		return nil, false
+	}
+
+	var differences []UnorderedFieldDifference
+
+	for _, name := range names {
+		r, exists := receivedFields[name]
+		if !exists {
+			return nil, false
+		}
+
+		if e := expectedFields[name]; !valuesEqual(e, r, nil) {
+			differences = append(differences, UnorderedFieldDifference{
+				Field:    name,
+				Expected: formatValue(e, nil),
+				Received: formatValue(r, nil),
+			})
+		}
+	}
+
+	if len(differences) == len(expectedFields) {
+		return nil, false
+	}
+
+	return differences, true
+}
+
+// Formatted representation of a value, like %+v but following pointers and without the ignored fields,
+// so that equal values are formatted the same whatever their addresses
+func formatValue(v reflect.Value, ignored map[string]bool) string {
+	return formatValueWith(v, ignored, formatScalar)
+}
+
+// Coarser representation than formatValue, equal for all the values valuesEqual considers equal,
+// e.g. for 25, int32(25), a numeric 25 and "25"
+func matchingKey(v reflect.Value, ignored map[string]bool) string {
+	return formatValueWith(v, ignored, scalarKey)
+}
+
+func formatValueWith(v reflect.Value, ignored map[string]bool, scalar func(v reflect.Value) (string, bool)) string {
+	v = indirectValue(v)
+
+	if !v.IsValid() {
+		return "<nil>"
+	}
+
+	if s, ok := scalar(v); ok {
+		return s
+	}
+
+	names, fields := fieldsOf(v, ignored)
+	if names == nil {
+		return fmt.Sprintf("%+v", v)
+	}
+
+	parts := make([]string, len(names))
+	for i, name := range names {
+		parts[i] = fmt.Sprintf("%s:%s", name, formatValueWith(fields[name], nil, scalar))
+	}
+
+	return fmt.Sprintf("{%s}", strings.Join(parts, " "))
+}
+
+// Deep equality following pointers, leaving the ignored fields of the outer value out.
+// Numbers are equal when their values are whatever their types, as generic scans return int32, int64
+// or pgtype.Numeric where tests write int or float literals, see scalarsEqual.
+// Slices and maps of different types, such as []string and the []any of a text[] column, are compared item by item.
+func valuesEqual(a, b reflect.Value, ignored map[string]bool) bool {
+	a, b = indirectValue(a), indirectValue(b)
 
-	for _, v := range first {
-		exists[fmt.Sprintf("%+v", v)] = true
+	if !a.IsValid() || !b.IsValid() {
+		return a.IsValid() == b.IsValid()
 	}
 
-	for _, v := range second {
-		if !exists[fmt.Sprintf("%+v", v)] {
+	if equal, ok := scalarsEqual(a, b); ok {
+		return equal
+	}
+
+	if a.Type() != b.Type() && !(isSequence(a) && isSequence(b)) && !(a.Kind() == reflect.Map && b.Kind() == reflect.Map) {
+		return false
+	}
+
+	if t, ok := timeValue(a); ok {
+		u, _ := timeValue(b)
+		return t.Equal(u)
+	}
+
+	switch a.Kind() {
+	case reflect.Struct:
+		for i := 0; i < a.NumField(); i++ {
+			if isIgnoredField(a.Type().Field(i), ignored) {
+				continue
+			}
+
+			if !valuesEqual(a.Field(i), b.Field(i), nil) {
+				return false
+			}
+		}
+
+		return true
+	case reflect.Map:
+		for _, k := range a.MapKeys() {
+			if ignored[normalizeColumnName(fmt.Sprintf("%v", k))] {
+				continue
+			}
+
+			if other := mapIndex(b, k); !other.IsValid() || !valuesEqual(a.MapIndex(k), other, nil) {
+				return false
+			}
+		}
+
+		for _, k := range b.MapKeys() {
+			if !ignored[normalizeColumnName(fmt.Sprintf("%v", k))] && !mapIndex(a, k).IsValid() {
+				return false
+			}
+		}
+
+		return true
+	case reflect.Slice, reflect.Array:
+		if a.Len() != b.Len() {
 			return false
 		}
+
+		for i := 0; i < a.Len(); i++ {
+			if ignored[fmt.Sprintf("[%d]", i)] {
+				continue
+			}
+
+			if !valuesEqual(a.Index(i), b.Index(i), nil) {
+				return false
+			}
+		}
+
+		return true
+	}
+
+	if a.CanInterface() && b.CanInterface() {
+		return reflect.DeepEqual(a.Interface(), b.Interface())
+	}
+
+	return fmt.Sprintf("%#v", a) == fmt.Sprintf("%#v", b)
+}
+
+func isSequence(v reflect.Value) bool {
+	return v.Kind() == reflect.Slice || v.Kind() == reflect.Array
+}
+
+// Entry of m for k, converting k to the key type of m, invalid when there is none
+func mapIndex(m, k reflect.Value) reflect.Value {
+	if k.Type() != m.Type().Key() {
+		if !k.Type().ConvertibleTo(m.Type().Key()) || k.Kind() != m.Type().Key().Kind() {
+			return reflect.Value{}
+		}
+
+		k = k.Convert(m.Type().Key())
+	}
+
+	return m.MapIndex(k)
+}
+
+// Value pointed to, through any number of pointers and interfaces, invalid for nil
+func indirectValue(v reflect.Value) reflect.Value {
+	for v.IsValid() && (v.Kind() == reflect.Interface || v.Kind() == reflect.Pointer) {
+		if v.IsNil() {
+			return reflect.Value{}
+		}
+
+		v = v.Elem()
+	}
+
+	return v
+}
+
+func timeValue(v reflect.Value) (time.Time, bool) {
+	if v.Type() != reflect.TypeOf(time.Time{}) || !v.CanInterface() {
+		return time.Time{}, false
+	}
+
+	return v.Interface().(time.Time), true
+}
+
+func isIgnoredField(f reflect.StructField, ignored map[string]bool) bool {
+	return ignored[normalizeColumnName(f.Name)] || ignored[normalizeColumnName(f.Tag.Get("db"))]
+}
+
+// Fields of structs, entries of maps and items of slices, keyed by name, key or index
+func fieldsOf(v reflect.Value, ignored map[string]bool) ([]string, map[string]reflect.Value) {
+	v = indirectValue(v)
+
+	if !v.IsValid() {
+		return nil, nil
+	}
+
+	var names []string
+
+	fields := make(map[string]reflect.Value)
+
+	switch v.Kind() {
+	case reflect.Struct:
+		if v.Type() == reflect.TypeOf(time.Time{}) || isScalarStruct(v) {
+			return nil, nil
+		}
+
+		for i := 0; i < v.NumField(); i++ {
+			f := v.Type().Field(i)
+			if isIgnoredField(f, ignored) {
+				continue
+			}
+
+			names = append(names, f.Name)
+			fields[f.Name] = v.Field(i)
+		}
+	case reflect.Map:
+		for _, k := range v.MapKeys() {
+			name := fmt.Sprintf("%v", k)
+			if ignored[normalizeColumnName(name)] {
+				continue
+			}
+
+			names = append(names, name)
+			fields[name] = v.MapIndex(k)
+		}
+
+		sort.Strings(names)
+	case reflect.Slice, reflect.Array:
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			return nil, nil
+		}
+
+		for i := 0; i < v.Len(); i++ {
+			name := fmt.Sprintf("[%d]", i)
+			if ignored[name] {
+				continue
+			}
+
+			names = append(names, name)
+			fields[name] = v.Index(i)
+		}
 	}
 
-	return true
+	return names, fields
 }
 
 func SkipUnitTestsWhenIntegrationTesting(t *testing.T) {
@@ -45,6 +537,13 @@ func SkipIntegrationTestsWhenUnitTesting(t *testing.T) {
 	}
 }
 
+// Golden files are rewritten only when SAKERHET_UPDATE_GOLDEN_FILES is set, e.g.
+// SAKERHET_UPDATE_GOLDEN_FILES=1 go test ./...
+// No -update flag is registered, as it would clash with the ones test binaries often define themselves
+func ShouldUpdateGoldenFiles() bool {
+	return os.Getenv(SakerhetUpdateGoldenFilesEnvVar) != ""
+}
+
 func GetIntegrationTestTimeout() time.Duration {
 	integrationTestTimeout := int64(60)
 
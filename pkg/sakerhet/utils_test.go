@@ -1,9 +1,13 @@
 package sakerhet_test
 
 import (
+	"math"
+	"strings"
 	"testing"
 
 	"github.com/averageflow/sakerhet/pkg/sakerhet"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgtype"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/suite"
 )
@@ -48,4 +52,151 @@ func (suite *UnorderedEqualTestSuite) TestUnorderedEqual() {
 		[][]byte{[]byte(`someBytes`)},
 		[][]byte{[]byte(`someBytes`), []byte(`moreBytes`)},
 	))
+
+	assert.False(suite.T(), sakerhet.UnorderedEqual(
+		[]string{"a", "a", "b"},
+		[]string{"a", "b", "b"},
+	))
+
+	assert.False(suite.T(), sakerhet.UnorderedEqual(
+		[][]byte{[]byte(`{"foo": "bar"}`), []byte(`{"foo": "bar"}`)},
+		[][]byte{[]byte(`{"foo": "bar"}`)},
+	))
+}
+
+func (suite *UnorderedEqualTestSuite) TestUnorderedEqualCustomised() {
+	type account struct {
+		UserID    int
+		Username  string
+		CreatedOn int `db:"created_on"`
+	}
+
+	assert.True(suite.T(), sakerhet.UnorderedEqualFunc(
+		[]string{"One", "two"},
+		[]string{"TWO", "one"},
+		strings.EqualFold,
+	))
+
+	assert.False(suite.T(), sakerhet.UnorderedEqualFunc(
+		[]string{"One", "one"},
+		[]string{"ONE", "two"},
+		strings.EqualFold,
+	))
+
+	assert.True(suite.T(), sakerhet.UnorderedEqualByKey(
+		[]account{{UserID: 1, Username: "myUser"}, {UserID: 2, Username: "otherUser"}},
+		[]account{{UserID: 7, Username: "otherUser"}, {UserID: 8, Username: "myUser"}},
+		func(a account) string { return a.Username },
+	))
+
+	assert.True(suite.T(), sakerhet.UnorderedEqualIgnoringFields(
+		[]account{{UserID: 1, Username: "myUser", CreatedOn: 1}},
+		[]account{{UserID: 5, Username: "myUser", CreatedOn: 999}},
+		"user_id", "created_on",
+	))
+
+	assert.False(suite.T(), sakerhet.UnorderedEqualIgnoringFields(
+		[]account{{UserID: 1, Username: "myUser"}, {UserID: 2, Username: "myUser"}},
+		[]account{{UserID: 1, Username: "myUser"}, {UserID: 2, Username: "otherUser"}},
+		"UserID",
+	))
+
+	assert.True(suite.T(), sakerhet.UnorderedEqualIgnoringFields(
+		[]map[string]any{{"username": "myUser", "created_on": 1}},
+		[]map[string]any{{"username": "myUser", "created_on": 2}},
+		"created_on",
+	))
+
+	// items of positional rows are ignored by index
+	assert.True(suite.T(), sakerhet.UnorderedEqualIgnoringFields(
+		[]any{[]any{nil, "myUser"}, []any{nil, "otherUser"}},
+		[]any{[]any{int32(2), "otherUser"}, []any{int32(1), "myUser"}},
+		"[0]",
+	))
+}
+
+func (suite *UnorderedEqualTestSuite) TestUnorderedDiff() {
+	type account struct {
+		Username string
+		Age      int
+	}
+
+	assert.True(suite.T(), sakerhet.UnorderedDiff(
+		[]string{"one", "two"},
+		[]string{"two", "one"},
+	).Equal())
+
+	diff := sakerhet.UnorderedDiff(
+		[]string{"one", "two", "two"},
+		[]string{"two", "three"},
+	)
+	assert.Equal(suite.T(), []string{"one", "two"}, diff.Missing)
+	assert.Equal(suite.T(), []string{"three"}, diff.Unexpected)
+	assert.Empty(suite.T(), diff.Mismatches)
+
+	accountDiff := sakerhet.UnorderedDiff(
+		[]account{{Username: "myUser", Age: 25}, {Username: "otherUser", Age: 30}},
+		[]account{{Username: "myUser", Age: 26}, {Username: "otherUser", Age: 30}, {Username: "newUser", Age: 40}},
+	)
+	assert.Empty(suite.T(), accountDiff.Missing)
+	assert.Equal(suite.T(), []account{{Username: "newUser", Age: 40}}, accountDiff.Unexpected)
+	assert.Equal(suite.T(), []sakerhet.UnorderedMismatch[account]{
+		{
+			Expected: account{Username: "myUser", Age: 25},
+			Received: account{Username: "myUser", Age: 26},
+			Fields:   []sakerhet.UnorderedFieldDifference{{Field: "Age", Expected: "25", Received: "26"}},
+		},
+	}, accountDiff.Mismatches)
+	assert.Contains(suite.T(), accountDiff.String(), "Age: expected 25, received 26")
+
+	rowDiff := sakerhet.UnorderedDiff(
+		[]any{[]any{"myUser", 25}},
+		[]any{[]any{"myUser", int32(26)}},
+	)
+	assert.Equal(suite.T(), []sakerhet.UnorderedFieldDifference{{Field: "[1]", Expected: "25", Received: "26"}}, rowDiff.Mismatches[0].Fields)
+}
+
+func (suite *UnorderedEqualTestSuite) TestUnorderedDiffFollowsPointers() {
+	type post struct {
+		Title string
+		Body  *string
+	}
+
+	a, b, c := "body", "body", "other body"
+
+	assert.True(suite.T(), sakerhet.UnorderedDiff(
+		[]post{{Title: "t", Body: &a}, {Title: "u"}},
+		[]post{{Title: "u"}, {Title: "t", Body: &b}},
+	).Equal())
+
+	diff := sakerhet.UnorderedDiff([]post{{Title: "t", Body: &a}}, []post{{Title: "t", Body: &c}})
+	assert.Equal(suite.T(), []sakerhet.UnorderedFieldDifference{{Field: "Body", Expected: "body", Received: "other body"}}, diff.Mismatches[0].Fields)
+
+	assert.True(suite.T(), sakerhet.UnorderedEqual([]any{[]any{"myUser", 25}}, []any{[]any{"myUser", int32(25)}}))
+
+	// formatted the same, but of different types
+	assert.False(suite.T(), sakerhet.UnorderedEqual([]any{"25"}, []any{25}))
+	assert.False(suite.T(), sakerhet.UnorderedEqual([]any{[]any{"true"}}, []any{[]any{true}}))
+}
+
+func (suite *UnorderedEqualTestSuite) TestUnorderedEqualScannedValues() {
+	assert.True(suite.T(), sakerhet.UnorderedEqual([]any{[]any{uint64(25)}}, []any{[]any{int64(25)}}))
+	assert.False(suite.T(), sakerhet.UnorderedEqual([]any{uint64(math.MaxUint64)}, []any{int64(-1)}))
+	assert.False(suite.T(), sakerhet.UnorderedEqual([]any{int64(9007199254740993)}, []any{int64(9007199254740992)}))
+	assert.True(suite.T(), sakerhet.UnorderedEqual([]any{float32(0.1)}, []any{0.1}))
+
+	var price pgtype.Numeric
+	assert.Nil(suite.T(), price.Scan("1.50"))
+
+	assert.True(suite.T(), sakerhet.UnorderedEqual([]any{"1.50"}, []any{price}))
+	assert.True(suite.T(), sakerhet.UnorderedEqual([]any{"1.5"}, []any{price}))
+	assert.True(suite.T(), sakerhet.UnorderedEqual([]any{1.5}, []any{price}))
+	assert.False(suite.T(), sakerhet.UnorderedEqual([]any{"1.51"}, []any{price}))
+
+	diff := sakerhet.UnorderedDiff([]any{[]any{"coffee", "1.51"}}, []any{[]any{"coffee", price}})
+	assert.Equal(suite.T(), []sakerhet.UnorderedFieldDifference{{Field: "[1]", Expected: "1.51", Received: "1.50"}}, diff.Mismatches[0].Fields)
+
+	id := uuid.New()
+	assert.True(suite.T(), sakerhet.UnorderedEqual([]any{id.String()}, []any{[16]byte(id)}))
+	assert.False(suite.T(), sakerhet.UnorderedEqual([]any{uuid.New().String()}, []any{[16]byte(id)}))
 }
@@ -0,0 +1,226 @@
+package sakerhet
+
+import (
+	"math/big"
+	"reflect"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+var numericType = reflect.TypeOf(pgtype.Numeric{})
+
+// Compare numbers, numerics and uuids across the Go types tests write them with and the ones scans return,
+// reporting false when a and b are not such values.
+// Integers and numerics are compared exactly, so bigints above 2^53 and numerics differing in their last digits
+// are told apart. Floats are only compared as floats against another number, a float32 as its shortest decimal form
+// so that a real column matches a Go literal. A numeric also equals a decimal string of the same value,
+// a uuid, scanned as [16]byte, its string form and bytes their string.
+func scalarsEqual(a, b reflect.Value) (bool, bool) {
+	x, xExact := exactNumber(a)
+	y, yExact := exactNumber(b)
+
+	if xExact && yExact {
+		return x.Cmp(y) == 0, true
+	}
+
+	f, xFloat := floatNumber(a)
+	g, yFloat := floatNumber(b)
+
+	if (xExact || xFloat) && (yExact || yFloat) {
+		if xExact {
+			f, _ = x.Float64()
+		}
+
+		if yExact {
+			g, _ = y.Float64()
+		}
+
+		return f == g, true
+	}
+
+	if a.Type() == numericType && b.Kind() == reflect.String {
+		a, b = b, a
+		x, xExact, y, yExact = y, yExact, x, xExact
+	}
+
+	if a.Kind() == reflect.String && b.Type() == numericType {
+		r, ok := new(big.Rat).SetString(a.String())
+		return ok && yExact && r.Cmp(y) == 0, true
+	}
+
+	if isBytes(a) && b.Kind() == reflect.String {
+		a, b = b, a
+	}
+
+	if a.Kind() == reflect.String && isBytes(b) {
+		return a.String() == string(b.Bytes()), true
+	}
+
+	if isUUIDBytes(a) && b.Kind() == reflect.String {
+		a, b = b, a
+	}
+
+	if a.Kind() == reflect.String && isUUIDBytes(b) {
+		id, err := uuid.Parse(a.String())
+		return err == nil && id == uuidBytes(b), true
+	}
+
+	return false, false
+}
+
+// Value of integers of any size and of finite numerics
+func exactNumber(v reflect.Value) (*big.Rat, bool) {
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return new(big.Rat).SetInt64(v.Int()), true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return new(big.Rat).SetInt(new(big.Int).SetUint64(v.Uint())), true
+	}
+
+	if v.Type() != numericType || !v.CanInterface() {
+		return nil, false
+	}
+
+	n := v.Interface().(pgtype.Numeric)
+	if !n.Valid || n.NaN || n.InfinityModifier != pgtype.Finite || n.Int == nil {
+		return nil, false
+	}
+
+	r := new(big.Rat).SetInt(n.Int)
+	scale := new(big.Rat).SetInt(new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(abs32(n.Exp))), nil))
+
+	if n.Exp > 0 {
+		r.Mul(r, scale)
+	} else if n.Exp < 0 {
+		r.Quo(r, scale)
+	}
+
+	return r, true
+}
+
+func floatNumber(v reflect.Value) (float64, bool) {
+	switch v.Kind() {
+	case reflect.Float32:
+		f, _ := strconv.ParseFloat(strconv.FormatFloat(v.Float(), 'g', -1, 32), 64)
+		return f, true
+	case reflect.Float64:
+		return v.Float(), true
+	}
+
+	return 0, false
+}
+
+func abs32(x int32) int32 {
+	if x < 0 {
+		return -x
+	}
+
+	return x
+}
+
+func isBytes(v reflect.Value) bool {
+	return v.Kind() == reflect.Slice && v.Type().Elem().Kind() == reflect.Uint8
+}
+
+func isUUIDBytes(v reflect.Value) bool {
+	return v.Kind() == reflect.Array && v.Len() == 16 && v.Type().Elem().Kind() == reflect.Uint8
+}
+
+func uuidBytes(v reflect.Value) uuid.UUID {
+	var id uuid.UUID
+
+	reflect.Copy(reflect.ValueOf(id[:]), v)
+
+	return id
+}
+
+// Structs formatted and compared as a single value
+func isScalarStruct(v reflect.Value) bool {
+	return v.Type() == numericType
+}
+
+// Readable form of times, numerics, bytes and float32 values, which %+v does not give
+func formatScalar(v reflect.Value) (string, bool) {
+	if t, ok := timeValue(v); ok {
+		return t.UTC().Format(time.RFC3339Nano), true
+	}
+
+	if v.Kind() == reflect.Float32 {
+		return strconv.FormatFloat(v.Float(), 'g', -1, 32), true
+	}
+
+	if isBytes(v) {
+		return string(v.Bytes()), true
+	}
+
+	if v.Type() != numericType || !v.CanInterface() {
+		return "", false
+	}
+
+	n := v.Interface().(pgtype.Numeric)
+
+	switch {
+	case !n.Valid:
+		return "<nil>", true
+	case n.NaN:
+		return "NaN", true
+	case n.InfinityModifier == pgtype.Infinity:
+		return "Infinity", true
+	case n.InfinityModifier == pgtype.NegativeInfinity:
+		return "-Infinity", true
+	}
+
+	r, _ := exactNumber(v)
+
+	scale := 0
+	if n.Exp < 0 {
+		scale = int(-n.Exp)
+	}
+
+	return r.FloatString(scale), true
+}
+
+// Key of a scalar shared by all the values scalarsEqual considers equal to it
+func scalarKey(v reflect.Value) (string, bool) {
+	if t, ok := timeValue(v); ok {
+		return t.UTC().Format(time.RFC3339Nano), true
+	}
+
+	if r, ok := exactNumber(v); ok {
+		f, _ := r.Float64()
+		return "#" + strconv.FormatFloat(f, 'g', -1, 64), true
+	}
+
+	if f, ok := floatNumber(v); ok {
+		return "#" + strconv.FormatFloat(f, 'g', -1, 64), true
+	}
+
+	if isUUIDBytes(v) {
+		return "uuid:" + uuidBytes(v).String(), true
+	}
+
+	var s string
+
+	switch {
+	case v.Kind() == reflect.String:
+		s = v.String()
+	case isBytes(v):
+		s = string(v.Bytes())
+	default:
+		return "", false
+	}
+
+	if r, ok := new(big.Rat).SetString(s); ok {
+		f, _ := r.Float64()
+		return "#" + strconv.FormatFloat(f, 'g', -1, 64), true
+	}
+
+	if id, err := uuid.Parse(s); err == nil {
+		return "uuid:" + id.String(), true
+	}
+
+	return s, true
+}